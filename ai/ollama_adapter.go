@@ -2,15 +2,17 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
+	"pr-agent-reviewer/httpclient"
 	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/types"
 )
 
 // OllamaAdapter implements the Provider interface for Ollama
@@ -25,13 +27,17 @@ type OllamaRequest struct {
 	Model    string `json:"model"`
 	Prompt   string `json:"prompt"`
 	System   string `json:"system,omitempty"`
+	// Format constrains the response, e.g. "json"
+	Format   string `json:"format,omitempty"`
 	Stream   bool   `json:"stream"`
 }
 
 // OllamaResponse represents a response from the Ollama API
 type OllamaResponse struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
 }
 
 // NewOllamaAdapter creates a new Ollama adapter
@@ -55,7 +61,7 @@ func NewOllamaAdapter() *OllamaAdapter {
 }
 
 // ReviewCode implements the Provider interface for Ollama
-func (a *OllamaAdapter) ReviewCode(changes []string) (string, error) {
+func (a *OllamaAdapter) ReviewCode(ctx context.Context, changes []types.FileChange) (*types.Review, error) {
 	prompt := `You are an experienced code reviewer. Please review the following code changes and provide a detailed review.
 Focus on:
 1. Code quality and best practices
@@ -64,10 +70,12 @@ Focus on:
 4. Performance implications
 5. Maintainability
 
-Format your response in markdown with clear sections.
+Format the summary and comments in markdown.
+
+` + reviewFormat + `
 
 Code changes to review:
-` + strings.Join(changes, "\n\n")
+` + types.FormatChanges(changes)
 
 	logger.LogInfo("Ollama review request - Model: %s, Prompt length: %d", a.model, len(prompt))
 
@@ -75,14 +83,15 @@ Code changes to review:
 		Model:  a.model,
 		Prompt: prompt,
 		System: "You are an expert code reviewer with deep knowledge of software engineering best practices. Provide detailed, actionable feedback on code changes.",
+		Format: "json",
 		Stream: false,
 	}
 
 	start := time.Now()
-	resp, err := a.sendRequest(req)
+	resp, err := a.sendRequest(ctx, req)
 	if err != nil {
 		logger.LogError("Ollama review request failed", err)
-		return "", fmt.Errorf("failed to get Ollama response: %v", err)
+		return nil, fmt.Errorf("failed to get Ollama response: %w", err)
 	}
 
 	duration := time.Since(start)
@@ -91,17 +100,17 @@ Code changes to review:
 	// Validate response
 	if len(content) < 50 {
 		logger.LogError("Ollama returned suspiciously short response", fmt.Errorf("response length: %d", len(content)))
-		return "", fmt.Errorf("invalid response from Ollama: response too short")
+		return nil, fmt.Errorf("invalid response from Ollama: response too short")
 	}
 
 	logger.LogInfo("Ollama response - Model: %s, Response length: %d, Duration: %v",
 		a.model, len(content), duration)
 
-	return content, nil
+	return parseReview(content), nil
 }
 
 // GenerateReviewSummary implements the Provider interface for Ollama
-func (a *OllamaAdapter) GenerateReviewSummary(review string) (string, error) {
+func (a *OllamaAdapter) GenerateReviewSummary(ctx context.Context, review string) (string, error) {
 	prompt := `You are a technical writer. Please provide a concise summary (2-3 sentences) of the following code review.
 Focus on the key points and main recommendations.
 
@@ -118,10 +127,10 @@ Code review to summarize:
 	}
 
 	start := time.Now()
-	resp, err := a.sendRequest(req)
+	resp, err := a.sendRequest(ctx, req)
 	if err != nil {
 		logger.LogError("Ollama summary request failed", err)
-		return "", fmt.Errorf("failed to get Ollama response: %v", err)
+		return "", fmt.Errorf("failed to get Ollama response: %w", err)
 	}
 
 	duration := time.Since(start)
@@ -139,37 +148,82 @@ Code review to summarize:
 	return content, nil
 }
 
+// SummarizeChanges implements the Provider interface for Ollama
+func (a *OllamaAdapter) SummarizeChanges(ctx context.Context, changes []types.FileChange) (string, error) {
+	return a.complete(ctx, "summarize", summarizeSystem, summarizePrompt(changes))
+}
+
+// SecurityReview implements the Provider interface for Ollama
+func (a *OllamaAdapter) SecurityReview(ctx context.Context, changes []types.FileChange) (string, error) {
+	return a.complete(ctx, "security review", securitySystem, securityPrompt(changes))
+}
+
+// AnswerQuestion implements the Provider interface for Ollama
+func (a *OllamaAdapter) AnswerQuestion(ctx context.Context, changes []types.FileChange, question string) (string, error) {
+	return a.complete(ctx, "question", answerSystem, answerPrompt(changes, question))
+}
+
+// ReplyToThread implements the Provider interface for Ollama
+func (a *OllamaAdapter) ReplyToThread(ctx context.Context, thread *types.ReviewThread, currentDiff string) (string, error) {
+	return a.complete(ctx, "thread reply", threadSystem, threadPrompt(thread, currentDiff))
+}
+
+// complete sends a single generate request and returns the reply
+func (a *OllamaAdapter) complete(ctx context.Context, kind, system, prompt string) (string, error) {
+	logger.LogInfo("Ollama %s request - Model: %s, Prompt length: %d", kind, a.model, len(prompt))
+
+	start := time.Now()
+	resp, err := a.sendRequest(ctx, OllamaRequest{
+		Model:  a.model,
+		Prompt: prompt,
+		System: system,
+		Stream: false,
+	})
+	if err != nil {
+		logger.LogError("Ollama "+kind+" request failed", err)
+		return "", fmt.Errorf("failed to get Ollama response: %w", err)
+	}
+
+	logger.LogInfo("Ollama response - Model: %s, Response length: %d, Duration: %v",
+		a.model, len(resp.Response), time.Since(start))
+
+	return resp.Response, nil
+}
+
 // sendRequest sends a request to the Ollama API
-func (a *OllamaAdapter) sendRequest(req OllamaRequest) (*OllamaResponse, error) {
+func (a *OllamaAdapter) sendRequest(ctx context.Context, req OllamaRequest) (*OllamaResponse, error) {
 	jsonData, err := json.Marshal(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/api/generate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	httpReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := a.httpClient.Post(
-		a.baseURL+"/api/generate",
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
+	resp, err := a.httpClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, &httpclient.StatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var ollamaResp OllamaResponse
 	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	// Validate response content
 	if ollamaResp.Response == "" {
 		return nil, fmt.Errorf("empty response from Ollama")
 	}
+	recordUsage(ctx, ollamaResp.PromptEvalCount, ollamaResp.EvalCount)
 
 	return &ollamaResp, nil
 } 
\ No newline at end of file
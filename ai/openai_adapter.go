@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"strings"
 	"time"
 
 	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/types"
 
 	"github.com/sashabaranov/go-openai"
 )
@@ -26,16 +26,16 @@ func NewOpenAIAdapter() *OpenAIAdapter {
 }
 
 // ReviewCode implements the Provider interface for OpenAI
-func (a *OpenAIAdapter) ReviewCode(changes []string) (string, error) {
+func (a *OpenAIAdapter) ReviewCode(ctx context.Context, changes []types.FileChange) (*types.Review, error) {
 	prompt := "Please review the following code changes and provide a detailed review. " +
-		"Focus on code quality, potential bugs, and best practices. " +
-		"Format the review in markdown.\n\nChanges:\n" + strings.Join(changes, "\n\n")
+		"Focus on code quality, potential bugs, and best practices.\n\n" +
+		reviewFormat + "\n\nChanges:\n" + types.FormatChanges(changes)
 
 	logger.LogOpenAIRequest("gpt-4", len(prompt))
 
 	start := time.Now()
 	resp, err := a.client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
 			Model: openai.GPT4,
 			Messages: []openai.ChatCompletionMessage{
@@ -53,24 +53,28 @@ func (a *OpenAIAdapter) ReviewCode(changes []string) (string, error) {
 
 	if err != nil {
 		logger.LogError("OpenAI review request failed", err)
-		return "", fmt.Errorf("failed to get OpenAI response: %v", err)
+		return nil, fmt.Errorf("failed to get OpenAI response: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from OpenAI")
 	}
 
 	duration := time.Since(start)
 	logger.LogOpenAIResponse("gpt-4", len(resp.Choices[0].Message.Content), duration)
+	recordUsage(ctx, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
 
-	return resp.Choices[0].Message.Content, nil
+	return parseReview(resp.Choices[0].Message.Content), nil
 }
 
 // GenerateReviewSummary implements the Provider interface for OpenAI
-func (a *OpenAIAdapter) GenerateReviewSummary(review string) (string, error) {
+func (a *OpenAIAdapter) GenerateReviewSummary(ctx context.Context, review string) (string, error) {
 	prompt := "Please provide a brief summary (2-3 sentences) of the following code review:\n\n" + review
 
 	logger.LogOpenAIRequest("gpt-4", len(prompt))
 
 	start := time.Now()
 	resp, err := a.client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
 			Model: openai.GPT4,
 			Messages: []openai.ChatCompletionMessage{
@@ -88,11 +92,86 @@ func (a *OpenAIAdapter) GenerateReviewSummary(review string) (string, error) {
 
 	if err != nil {
 		logger.LogError("OpenAI summary request failed", err)
-		return "", fmt.Errorf("failed to get OpenAI response: %v", err)
+		return "", fmt.Errorf("failed to get OpenAI response: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("empty response from OpenAI")
+	}
+
+	duration := time.Since(start)
+	logger.LogOpenAIResponse("gpt-4", len(resp.Choices[0].Message.Content), duration)
+	recordUsage(ctx, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+
+	return resp.Choices[0].Message.Content, nil
+} 
+
+// SummarizeChanges implements the Provider interface for OpenAI
+func (a *OpenAIAdapter) SummarizeChanges(ctx context.Context, changes []types.FileChange) (string, error) {
+	return a.complete(ctx, "summarize", summarizeSystem, summarizePrompt(changes))
+}
+
+// SecurityReview implements the Provider interface for OpenAI
+func (a *OpenAIAdapter) SecurityReview(ctx context.Context, changes []types.FileChange) (string, error) {
+	return a.complete(ctx, "security review", securitySystem, securityPrompt(changes))
+}
+
+// AnswerQuestion implements the Provider interface for OpenAI
+func (a *OpenAIAdapter) AnswerQuestion(ctx context.Context, changes []types.FileChange, question string) (string, error) {
+	return a.complete(ctx, "question", answerSystem, answerPrompt(changes, question))
+}
+
+// ReplyToThread implements the Provider interface for OpenAI. The bot's own
+// comments are sent as assistant messages so the model sees the conversation.
+func (a *OpenAIAdapter) ReplyToThread(ctx context.Context, thread *types.ReviewThread, currentDiff string) (string, error) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: threadSystem},
+		{Role: openai.ChatMessageRoleUser, Content: threadContext(thread, currentDiff)},
+	}
+	for _, comment := range thread.Comments {
+		if comment.Own {
+			messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: comment.Body})
+		} else {
+			messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "@" + comment.Author + ": " + comment.Body})
+		}
+	}
+	return a.chat(ctx, "thread reply", messages)
+}
+
+// complete sends a single chat completion request and returns the reply
+func (a *OpenAIAdapter) complete(ctx context.Context, kind, system, prompt string) (string, error) {
+	return a.chat(ctx, kind, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: system},
+		{Role: openai.ChatMessageRoleUser, Content: prompt},
+	})
+}
+
+// chat sends a chat completion request and returns the reply
+func (a *OpenAIAdapter) chat(ctx context.Context, kind string, messages []openai.ChatCompletionMessage) (string, error) {
+	size := 0
+	for _, message := range messages {
+		size += len(message.Content)
+	}
+	logger.LogOpenAIRequest("gpt-4", size)
+
+	start := time.Now()
+	resp, err := a.client.CreateChatCompletion(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:    openai.GPT4,
+			Messages: messages,
+		},
+	)
+	if err != nil {
+		logger.LogError("OpenAI "+kind+" request failed", err)
+		return "", fmt.Errorf("failed to get OpenAI response: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("empty response from OpenAI")
 	}
 
 	duration := time.Since(start)
 	logger.LogOpenAIResponse("gpt-4", len(resp.Choices[0].Message.Content), duration)
+	recordUsage(ctx, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
 
 	return resp.Choices[0].Message.Content, nil
-} 
\ No newline at end of file
+}
@@ -0,0 +1,129 @@
+package ai
+
+import (
+	"encoding/json"
+	"strings"
+
+	"pr-agent-reviewer/types"
+)
+
+// Prompts shared by the adapters for on-demand commands
+const (
+	summarizeSystem = "You are a technical writer. Summarize code changes for reviewers."
+	securitySystem  = "You are an application security expert. Review code changes for vulnerabilities."
+	answerSystem    = "You are an experienced code reviewer. Answer questions about code changes accurately and concisely."
+)
+
+func summarizePrompt(changes []types.FileChange) string {
+	return "Please summarize the following code changes in a few bullet points. " +
+		"Describe what changed and why it matters. Format the summary in markdown.\n\nChanges:\n" +
+		types.FormatChanges(changes)
+}
+
+func securityPrompt(changes []types.FileChange) string {
+	return "Please review the following code changes for security issues only. " +
+		"Look for injection, authentication and authorization flaws, secrets in code, " +
+		"unsafe input handling and insecure dependencies. For each issue give the file, " +
+		"the risk and a fix. If you find no issues, say so. Format the review in markdown.\n\nChanges:\n" +
+		types.FormatChanges(changes)
+}
+
+func answerPrompt(changes []types.FileChange, question string) string {
+	return "Answer the following question about the code changes below. " +
+		"Format the answer in markdown.\n\nQuestion: " + question + "\n\nChanges:\n" +
+		types.FormatChanges(changes)
+}
+
+const threadSystem = "You are the code reviewer who started this review thread. " +
+	"Reply to the latest message from the developer. Explain your earlier comments when asked, " +
+	"and when the developer says an issue is fixed, check the current diff and confirm the fix " +
+	"or explain what is still wrong. Keep the reply short and format it in markdown."
+
+// threadContext describes the code a review thread is about
+func threadContext(thread *types.ReviewThread, currentDiff string) string {
+	var b strings.Builder
+	if thread.File != "" {
+		b.WriteString("File: " + thread.File + "\n\n")
+	}
+	if thread.DiffHunk != "" {
+		b.WriteString("Diff hunk the thread was started on:\n" + thread.DiffHunk + "\n\n")
+	}
+	if currentDiff != "" {
+		b.WriteString("Current diff of the file:\n" + currentDiff + "\n\n")
+	}
+	return b.String()
+}
+
+// threadPrompt flattens a review thread into a single prompt for models
+// without chat messages
+func threadPrompt(thread *types.ReviewThread, currentDiff string) string {
+	var b strings.Builder
+	b.WriteString(threadContext(thread, currentDiff))
+	b.WriteString("Conversation:\n")
+	for _, comment := range thread.Comments {
+		author := "@" + comment.Author
+		if comment.Own {
+			author = "You"
+		}
+		b.WriteString(author + ": " + comment.Body + "\n\n")
+	}
+	b.WriteString("Write your reply.")
+	return b.String()
+}
+
+// reviewFormat asks for a review as JSON so that findings can be posted as
+// inline comments on the lines they are about
+const reviewFormat = `Respond with a single JSON object and nothing else, in this form:
+{
+  "summary": "the overall review in markdown",
+  "findings": [
+    {"file": "path/of/file", "line": 42, "severity": "major", "category": "bug", "comment": "the issue and how to fix it, in markdown"},
+    {"file": "path/of/file", "start_line": 50, "line": 52, "severity": "nit", "category": "style", "comment": "the issue", "suggestion": "replacement code"}
+  ]
+}
+Each finding must be about one line or a short range of lines: "line" is the (last) line's number in
+the new version of the file, taken from the "+" side of the hunk headers, and must be an added or
+unchanged line shown in the diff. For a range, "start_line" is its first line; the range must stay
+inside one hunk. When you propose a concrete fix, put the exact code that replaces the lines from
+"start_line" (or "line") to "line" in "suggestion", keeping the original indentation; otherwise omit
+"suggestion". "severity" is one of "critical" (must be fixed before merging: data loss, crashes,
+vulnerabilities), "major" (likely bug or significant problem), "minor" (should be improved) or "nit"
+(style or taste). "category" is one of "security", "bug", "performance", "maintainability" or "style".
+Put remarks that are not about specific lines in the summary, and leave "findings" empty when there
+is nothing to fix.`
+
+// parseReview reads a review in the reviewFormat JSON. Replies that are not
+// valid JSON are kept whole as the review body, without findings.
+func parseReview(content string) *types.Review {
+	text := strings.TrimSpace(content)
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+
+	// A reply is structured when it has either field of the schema, even if
+	// it is empty: a review without a summary or findings found no issues
+	var reply struct {
+		Summary  *string         `json:"summary"`
+		Findings []types.Finding `json:"findings"`
+	}
+	if err := json.Unmarshal([]byte(text), &reply); err != nil || (reply.Summary == nil && reply.Findings == nil) {
+		return &types.Review{Body: content}
+	}
+
+	review := &types.Review{Structured: true}
+	if reply.Summary != nil {
+		review.Body = strings.TrimSpace(*reply.Summary)
+	}
+	for _, finding := range reply.Findings {
+		if finding.File == "" || finding.Line <= 0 || strings.TrimSpace(finding.Body) == "" {
+			continue
+		}
+		finding.Severity = strings.ToLower(strings.TrimSpace(finding.Severity))
+		finding.Category = strings.ToLower(strings.TrimSpace(finding.Category))
+		review.Findings = append(review.Findings, finding)
+	}
+	if review.Body == "" && len(review.Findings) == 0 {
+		review.Body = "No issues found."
+	}
+	return review
+}
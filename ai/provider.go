@@ -1,17 +1,37 @@
 package ai
 
+import (
+	"context"
+
+	"pr-agent-reviewer/types"
+)
+
 // Provider defines the interface for AI review providers
 type Provider interface {
-	// ReviewCode reviews the provided code changes and returns a detailed review
-	ReviewCode(changes []string) (string, error)
+	// ReviewCode reviews the provided code changes and returns a detailed
+	// review with findings about specific lines
+	ReviewCode(ctx context.Context, changes []types.FileChange) (*types.Review, error)
 	
 	// GenerateReviewSummary generates a brief summary of a review
-	GenerateReviewSummary(review string) (string, error)
+	GenerateReviewSummary(ctx context.Context, review string) (string, error)
+
+	// SummarizeChanges summarizes the provided code changes
+	SummarizeChanges(ctx context.Context, changes []types.FileChange) (string, error)
+
+	// SecurityReview reviews the provided code changes for security issues only
+	SecurityReview(ctx context.Context, changes []types.FileChange) (string, error)
+
+	// AnswerQuestion answers a question about the provided code changes
+	AnswerQuestion(ctx context.Context, changes []types.FileChange, question string) (string, error)
+
+	// ReplyToThread replies to the latest comment in a review thread the bot
+	// started. currentDiff is the file's diff at the PR head, if still changed.
+	ReplyToThread(ctx context.Context, thread *types.ReviewThread, currentDiff string) (string, error)
 }
 
 // ReviewRequest represents a request for code review
 type ReviewRequest struct {
-	Changes []string
+	Changes []types.FileChange
 	Model   string
 }
 
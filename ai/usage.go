@@ -0,0 +1,42 @@
+package ai
+
+import (
+	"context"
+	"sync"
+)
+
+// Usage accumulates the tokens spent by the AI calls made with a context
+type Usage struct {
+	mu               sync.Mutex
+	promptTokens     int
+	completionTokens int
+}
+
+type usageKey struct{}
+
+// WithUsage returns a context whose AI calls add their token counts to the
+// returned Usage
+func WithUsage(ctx context.Context) (context.Context, *Usage) {
+	usage := &Usage{}
+	return context.WithValue(ctx, usageKey{}, usage), usage
+}
+
+// Tokens returns the prompt and completion tokens recorded so far
+func (u *Usage) Tokens() (prompt, completion int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.promptTokens, u.completionTokens
+}
+
+// recordUsage adds token counts to the Usage attached to the context, if any
+func recordUsage(ctx context.Context, prompt, completion int) {
+	usage, ok := ctx.Value(usageKey{}).(*Usage)
+	if !ok {
+		return
+	}
+
+	usage.mu.Lock()
+	usage.promptTokens += prompt
+	usage.completionTokens += completion
+	usage.mu.Unlock()
+}
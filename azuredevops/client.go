@@ -0,0 +1,609 @@
+package azuredevops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	diffutil "pr-agent-reviewer/diff"
+	"pr-agent-reviewer/httpclient"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/sticky"
+	"pr-agent-reviewer/types"
+)
+
+const apiVersion = "7.1"
+
+// Thread statuses used when creating comment threads
+const (
+	threadActive = 1
+	threadClosed = 4
+)
+
+// Client represents an Azure DevOps Repos client
+type Client struct {
+	orgURL     string
+	token      string
+	httpClient *http.Client
+
+	// userID is the bot's own identity, looked up once
+	userMu sync.Mutex
+	userID string
+}
+
+// NewClient creates a new Azure DevOps client. Repositories are named
+// "project/repository" within the organization at AZURE_DEVOPS_ORG_URL.
+func NewClient() *Client {
+	token := os.Getenv("AZURE_DEVOPS_TOKEN")
+	if token == "" {
+		logger.LogError("AZURE_DEVOPS_TOKEN environment variable is not set", nil)
+		return nil
+	}
+
+	orgURL := os.Getenv("AZURE_DEVOPS_ORG_URL")
+	if orgURL == "" {
+		logger.LogError("AZURE_DEVOPS_ORG_URL environment variable is not set", nil)
+		return nil
+	}
+
+	return &Client{
+		orgURL:     strings.TrimSuffix(orgURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// iteration is a push to a pull request as returned by the API
+type iteration struct {
+	ID              int `json:"id"`
+	SourceRefCommit struct {
+		CommitID string `json:"commitId"`
+	} `json:"sourceRefCommit"`
+}
+
+// changeEntry is a changed file of an iteration as returned by the API
+type changeEntry struct {
+	ChangeType string `json:"changeType"`
+	Item       struct {
+		Path             string `json:"path"`
+		ObjectID         string `json:"objectId"`
+		OriginalObjectID string `json:"originalObjectId"`
+		IsFolder         bool   `json:"isFolder"`
+	} `json:"item"`
+	OriginalPath string `json:"originalPath"`
+}
+
+// threadComment is a comment of a thread as returned by the API
+type threadComment struct {
+	ID      int    `json:"id"`
+	Content string `json:"content"`
+	Type    string `json:"commentType"`
+	Deleted bool   `json:"isDeleted"`
+	Author  struct {
+		ID         string `json:"id"`
+		UniqueName string `json:"uniqueName"`
+	} `json:"author"`
+}
+
+// GetChanges implements the vcs.Provider interface. The changes of the latest
+// iteration are compared with the target branch.
+func (c *Client) GetChanges(ctx context.Context, repo string, prNumber int) ([]types.FileChange, error) {
+	logger.LogInfo("Getting changes for PR #%d in %s", prNumber, repo)
+
+	iterations, err := c.iterations(ctx, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	return c.iterationChanges(ctx, repo, prNumber, iterations[len(iterations)-1].ID, 0)
+}
+
+// GetChangesBetween implements the vcs.Provider interface. The commits are
+// matched to iterations; when the earlier commit is not the head of any
+// iteration all changes are returned.
+func (c *Client) GetChangesBetween(ctx context.Context, repo string, prNumber int, fromSHA, toSHA string) ([]types.FileChange, error) {
+	logger.LogInfo("Getting changes for PR #%d in %s between %s and %s", prNumber, repo, fromSHA, toSHA)
+
+	iterations, err := c.iterations(ctx, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := 0, iterations[len(iterations)-1].ID
+	for _, it := range iterations {
+		switch it.SourceRefCommit.CommitID {
+		case fromSHA:
+			from = it.ID
+		case toSHA:
+			to = it.ID
+		}
+	}
+	if from == 0 {
+		logger.LogInfo("No iteration of PR #%d ends at %s, getting all changes", prNumber, fromSHA)
+	}
+	return c.iterationChanges(ctx, repo, prNumber, to, from)
+}
+
+// GetHeadSHA implements the vcs.Provider interface
+func (c *Client) GetHeadSHA(ctx context.Context, repo string, prNumber int) (string, error) {
+	var pr struct {
+		LastMergeSourceCommit struct {
+			CommitID string `json:"commitId"`
+		} `json:"lastMergeSourceCommit"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/pullRequests/%d", repoPath(repo), prNumber), nil, &pr); err != nil {
+		logger.LogError("Failed to get PR details", err)
+		return "", fmt.Errorf("failed to get PR details: %w", err)
+	}
+	return pr.LastMergeSourceCommit.CommitID, nil
+}
+
+func (c *Client) iterations(ctx context.Context, repo string, prNumber int) ([]iteration, error) {
+	var result struct {
+		Value []iteration `json:"value"`
+	}
+	path := fmt.Sprintf("%s/pullRequests/%d/iterations", repoPath(repo), prNumber)
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		logger.LogError("Failed to get PR iterations", err)
+		return nil, fmt.Errorf("failed to get PR iterations: %w", err)
+	}
+	if len(result.Value) == 0 {
+		return nil, fmt.Errorf("PR #%d has no iterations", prNumber)
+	}
+	return result.Value, nil
+}
+
+// iterationChanges returns the changes of an iteration compared with an
+// earlier one, or with the target branch when compareTo is 0. Azure DevOps
+// does not serve diffs, so each file's patch is computed from its blobs.
+func (c *Client) iterationChanges(ctx context.Context, repo string, prNumber, iterationID, compareTo int) ([]types.FileChange, error) {
+	var entries []changeEntry
+	for skip := 0; ; {
+		var page struct {
+			ChangeEntries []changeEntry `json:"changeEntries"`
+			NextSkip      int           `json:"nextSkip"`
+		}
+		path := fmt.Sprintf("%s/pullRequests/%d/iterations/%d/changes?$compareTo=%d&$skip=%d",
+			repoPath(repo), prNumber, iterationID, compareTo, skip)
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			logger.LogError("Failed to get PR changes", err)
+			return nil, fmt.Errorf("failed to get PR changes: %w", err)
+		}
+		entries = append(entries, page.ChangeEntries...)
+		if page.NextSkip == 0 {
+			break
+		}
+		skip = page.NextSkip
+	}
+
+	var changes []types.FileChange
+	for _, entry := range entries {
+		if entry.Item.IsFolder {
+			continue
+		}
+
+		var oldText, newText string
+		var err error
+		if entry.Item.OriginalObjectID != "" {
+			if oldText, err = c.blob(ctx, repo, entry.Item.OriginalObjectID); err != nil {
+				return nil, err
+			}
+		}
+		if entry.Item.ObjectID != "" && !strings.Contains(entry.ChangeType, "delete") {
+			if newText, err = c.blob(ctx, repo, entry.Item.ObjectID); err != nil {
+				return nil, err
+			}
+		}
+
+		path := strings.TrimPrefix(entry.Item.Path, "/")
+		// Blobs with NUL bytes are binary and are not diffed
+		var patch string
+		binary := strings.ContainsRune(oldText, 0) || strings.ContainsRune(newText, 0)
+		if !binary {
+			patch = diffutil.Unified(oldText, newText, 3)
+		}
+		change := diffutil.NewFileChange(path, patch)
+		change.Binary = binary
+		switch {
+		case strings.Contains(entry.ChangeType, "add"):
+			change.Status = types.FileAdded
+		case strings.Contains(entry.ChangeType, "delete"):
+			change.Status = types.FileRemoved
+		case strings.Contains(entry.ChangeType, "rename"):
+			change.Status = types.FileRenamed
+			change.OldPath = strings.TrimPrefix(entry.OriginalPath, "/")
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+func (c *Client) blob(ctx context.Context, repo, objectID string) (string, error) {
+	text, err := c.getText(ctx, fmt.Sprintf("%s/blobs/%s?$format=octetstream", repoPath(repo), url.PathEscape(objectID)))
+	if err != nil {
+		logger.LogError("Failed to get file content", err)
+		return "", fmt.Errorf("failed to get file content: %w", err)
+	}
+	return text, nil
+}
+
+// CreateReview implements the vcs.Provider interface. The review is posted as
+// a PR-level comment thread.
+func (c *Client) CreateReview(ctx context.Context, repo string, prNumber int, review *types.Review) error {
+	logger.LogInfo("Creating review for PR #%d in %s", prNumber, repo)
+
+	// Every thread is tagged with the review ID, so that a retry after a
+	// partial failure opens only the threads that are missing
+	posted, err := c.postedParts(ctx, repo, prNumber, review.ID)
+	if err != nil {
+		return err
+	}
+
+	if !posted[partReview] {
+		if err := c.createThread(ctx, repo, prNumber, sticky.Tag(review.Body, review.ID, partReview), threadActive, nil); err != nil {
+			logger.LogError("Failed to create PR review", err)
+			return fmt.Errorf("failed to create PR review: %w", err)
+		}
+	}
+
+	// The review itself is posted, so findings that cannot be posted inline
+	// are collected in a follow-up thread rather than failing it
+	var rejected []types.Finding
+	for _, finding := range review.Findings {
+		part := findingPart(finding)
+		if posted[part] {
+			continue
+		}
+		body := sticky.Tag(finding.CommentBody(""), review.ID, part)
+		if err := c.CreateInlineComment(ctx, repo, prNumber, finding.File, finding.Line, body); err != nil {
+			rejected = append(rejected, finding)
+		}
+	}
+	if len(rejected) > 0 && !posted[partFindings] {
+		body := strings.TrimSpace(types.FormatFindings("Findings", rejected))
+		if err := c.createThread(ctx, repo, prNumber, sticky.Tag(body, review.ID, partFindings), threadActive, nil); err != nil {
+			logger.LogError("Failed to post findings that could not be anchored", err)
+		}
+	}
+
+	c.submitVerdict(ctx, repo, prNumber, review.Verdict)
+	return nil
+}
+
+// Parts of a review that are posted as PR-level threads
+const (
+	partReview   = "review"
+	partFindings = "findings"
+)
+
+// findingPart names the inline thread of a finding within its review
+func findingPart(finding types.Finding) string {
+	return fmt.Sprintf("%s:%d", finding.File, finding.Line)
+}
+
+// postedParts returns the parts of a review that the bot has already posted
+// on the PR
+func (c *Client) postedParts(ctx context.Context, repo string, prNumber int, reviewID string) (map[string]bool, error) {
+	userID, err := c.botUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Value []struct {
+			Comments []threadComment `json:"comments"`
+		} `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/pullRequests/%d/threads", repoPath(repo), prNumber), nil, &result); err != nil {
+		logger.LogError("Failed to list PR threads", err)
+		return nil, fmt.Errorf("failed to list PR threads: %w", err)
+	}
+
+	posted := make(map[string]bool)
+	for _, thread := range result.Value {
+		if len(thread.Comments) == 0 {
+			continue
+		}
+		first := thread.Comments[0]
+		if first.Deleted || first.Author.ID != userID {
+			continue
+		}
+		if part, ok := sticky.Tagged(first.Content, reviewID); ok {
+			posted[part] = true
+		}
+	}
+	return posted, nil
+}
+
+// Reviewer votes
+const (
+	voteApproved         = 10
+	voteNone             = 0
+	voteWaitingForAuthor = -5
+)
+
+// submitVerdict votes on the PR as the bot: approved, waiting for the
+// author when the review requests changes, or no vote for a plain comment,
+// so that an approval of an earlier head does not outlive it. Failures are
+// logged, since the review itself is already posted.
+func (c *Client) submitVerdict(ctx context.Context, repo string, prNumber int, verdict types.Verdict) {
+	var vote int
+	switch verdict {
+	case types.VerdictApprove:
+		vote = voteApproved
+	case types.VerdictRequestChanges:
+		vote = voteWaitingForAuthor
+	default:
+		vote = voteNone
+	}
+
+	userID, err := c.botUserID(ctx)
+	if err != nil {
+		logger.LogError("Failed to submit PR vote", err)
+		return
+	}
+
+	logger.LogInfo("Voting %d on PR #%d in %s", vote, prNumber, repo)
+	path := fmt.Sprintf("%s/pullRequests/%d/reviewers/%s", repoPath(repo), prNumber, url.PathEscape(userID))
+	if err := c.do(ctx, http.MethodPut, path, map[string]int{"vote": vote}, nil); err != nil {
+		logger.LogError("Failed to submit PR vote", err)
+	}
+}
+
+// CreateInlineComment opens a comment thread on a line of the new version of
+// a file
+func (c *Client) CreateInlineComment(ctx context.Context, repo string, prNumber int, path string, line int, body string) error {
+	logger.LogInfo("Creating inline comment on %s:%d for PR #%d in %s", path, line, prNumber, repo)
+
+	threadContext := map[string]interface{}{
+		"filePath":       "/" + strings.TrimPrefix(path, "/"),
+		"rightFileStart": map[string]int{"line": line, "offset": 1},
+		"rightFileEnd":   map[string]int{"line": line, "offset": 1},
+	}
+	if err := c.createThread(ctx, repo, prNumber, body, threadActive, threadContext); err != nil {
+		logger.LogError("Failed to create inline comment", err)
+		return fmt.Errorf("failed to create inline comment: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) createThread(ctx context.Context, repo string, prNumber int, body string, status int, threadContext interface{}) error {
+	payload := map[string]interface{}{
+		"comments": []map[string]interface{}{
+			{"parentCommentId": 0, "content": body, "commentType": 1},
+		},
+		"status": status,
+	}
+	if threadContext != nil {
+		payload["threadContext"] = threadContext
+	}
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("%s/pullRequests/%d/threads", repoPath(repo), prNumber), payload, nil)
+}
+
+// PostComment implements the vcs.Provider interface. The thread ID is the ID
+// of the comment thread to reply to; without one a new, closed thread is
+// opened so that it does not block completion of the PR.
+func (c *Client) PostComment(ctx context.Context, repo string, prNumber int, threadID, body string) error {
+	if threadID == "" {
+		logger.LogInfo("Posting comment on PR #%d in %s", prNumber, repo)
+		if err := c.createThread(ctx, repo, prNumber, body, threadClosed, nil); err != nil {
+			logger.LogError("Failed to post PR comment", err)
+			return fmt.Errorf("failed to post PR comment: %w", err)
+		}
+		return nil
+	}
+
+	logger.LogInfo("Replying to thread %s on PR #%d in %s", threadID, prNumber, repo)
+	path := fmt.Sprintf("%s/pullRequests/%d/threads/%s/comments", repoPath(repo), prNumber, url.PathEscape(threadID))
+	payload := map[string]interface{}{"parentCommentId": 1, "content": body, "commentType": 1}
+	if err := c.do(ctx, http.MethodPost, path, payload, nil); err != nil {
+		logger.LogError("Failed to reply to PR thread", err)
+		return fmt.Errorf("failed to reply to PR thread: %w", err)
+	}
+	return nil
+}
+
+// GetThread implements the vcs.Provider interface
+func (c *Client) GetThread(ctx context.Context, repo string, prNumber int, threadID string) (*types.ReviewThread, error) {
+	logger.LogInfo("Getting thread %s on PR #%d in %s", threadID, prNumber, repo)
+
+	userID, err := c.botUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Comments      []threadComment `json:"comments"`
+		ThreadContext *struct {
+			FilePath       string `json:"filePath"`
+			RightFileStart *struct {
+				Line int `json:"line"`
+			} `json:"rightFileStart"`
+		} `json:"threadContext"`
+	}
+	path := fmt.Sprintf("%s/pullRequests/%d/threads/%s", repoPath(repo), prNumber, url.PathEscape(threadID))
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		logger.LogError("Failed to get PR thread", err)
+		return nil, fmt.Errorf("failed to get PR thread: %w", err)
+	}
+
+	thread := &types.ReviewThread{ID: threadID}
+	for _, comment := range result.Comments {
+		if comment.Deleted || comment.Type == "system" {
+			continue
+		}
+		thread.Comments = append(thread.Comments, types.ThreadComment{
+			Author: comment.Author.UniqueName,
+			Body:   comment.Content,
+			Own:    comment.Author.ID == userID,
+		})
+	}
+
+	// Azure DevOps threads carry no diff hunk, so cut it from the file's diff
+	if anchor := result.ThreadContext; anchor != nil && anchor.FilePath != "" {
+		thread.File = strings.TrimPrefix(anchor.FilePath, "/")
+		if anchor.RightFileStart != nil {
+			changes, err := c.GetChanges(ctx, repo, prNumber)
+			if err != nil {
+				return nil, err
+			}
+			for _, change := range changes {
+				if change.Path == thread.File {
+					thread.DiffHunk = diffutil.HunkAt(change.Patch, anchor.RightFileStart.Line)
+					break
+				}
+			}
+		}
+	}
+
+	return thread, nil
+}
+
+// IsCollaborator implements the vcs.Provider interface. Azure DevOps has no
+// per-user repository permission lookup for other users, so members of the
+// project's default team are treated as collaborators. username is matched
+// against the member's unique name or identity ID.
+func (c *Client) IsCollaborator(ctx context.Context, repo, username string) (bool, error) {
+	project, _, _ := strings.Cut(repo, "/")
+
+	var info struct {
+		DefaultTeam struct {
+			ID string `json:"id"`
+		} `json:"defaultTeam"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/_apis/projects/"+url.PathEscape(project), nil, &info); err != nil {
+		logger.LogError("Failed to get project", err)
+		return false, fmt.Errorf("failed to get project: %w", err)
+	}
+
+	for skip := 0; ; skip += 100 {
+		var members struct {
+			Value []struct {
+				Identity struct {
+					ID         string `json:"id"`
+					UniqueName string `json:"uniqueName"`
+				} `json:"identity"`
+			} `json:"value"`
+		}
+		path := fmt.Sprintf("/_apis/projects/%s/teams/%s/members?$top=100&$skip=%d",
+			url.PathEscape(project), url.PathEscape(info.DefaultTeam.ID), skip)
+		if err := c.do(ctx, http.MethodGet, path, nil, &members); err != nil {
+			logger.LogError("Failed to list team members", err)
+			return false, fmt.Errorf("failed to list team members: %w", err)
+		}
+
+		for _, member := range members.Value {
+			if strings.EqualFold(member.Identity.UniqueName, username) || member.Identity.ID == username {
+				return true, nil
+			}
+		}
+		if len(members.Value) < 100 {
+			return false, nil
+		}
+	}
+}
+
+// botUserID returns the identity ID of the owner of the token
+func (c *Client) botUserID(ctx context.Context) (string, error) {
+	c.userMu.Lock()
+	defer c.userMu.Unlock()
+	if c.userID != "" {
+		return c.userID, nil
+	}
+
+	var data struct {
+		AuthenticatedUser struct {
+			ID string `json:"id"`
+		} `json:"authenticatedUser"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/_apis/connectionData", nil, &data); err != nil {
+		logger.LogError("Failed to get authenticated user", err)
+		return "", fmt.Errorf("failed to get authenticated user: %w", err)
+	}
+	c.userID = data.AuthenticatedUser.ID
+	return c.userID, nil
+}
+
+// do sends a JSON request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// getText fetches a raw resource such as file content
+func (c *Client) getText(ctx context.Context, path string) (string, error) {
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return string(data), nil
+}
+
+// send sends an authenticated request to a path relative to the organization
+// URL, adding the API version
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.orgURL+path+sep+"api-version="+apiVersion, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.SetBasicAuth("", c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &httpclient.StatusError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	return resp, nil
+}
+
+// repoPath returns the API path of a "project/repository" repository
+func repoPath(repo string) string {
+	project, name, _ := strings.Cut(repo, "/")
+	return "/" + url.PathEscape(project) + "/_apis/git/repositories/" + url.PathEscape(name)
+}
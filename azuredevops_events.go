@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/policy"
+	"pr-agent-reviewer/queue"
+	"pr-agent-reviewer/types"
+	"pr-agent-reviewer/webhook"
+)
+
+// azureDevOpsEvents routes Azure DevOps service hooks by the eventType of
+// their payload
+var azureDevOpsEvents = webhook.NewDispatcher("Azure DevOps")
+
+func init() {
+	azureDevOpsEvents.Register("git.pullrequest.created", webhook.Typed(handleAzureDevOpsPullRequest))
+	azureDevOpsEvents.Register("git.pullrequest.updated", webhook.Typed(handleAzureDevOpsPullRequest))
+}
+
+func handleAzureDevOpsWebhook(w http.ResponseWriter, r *http.Request, body []byte) {
+	var event struct {
+		EventType string `json:"eventType"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		logger.LogError("Failed to decode Azure DevOps webhook payload", err)
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	azureDevOpsEvents.Dispatch(w, r, event.EventType, body)
+}
+
+func handleAzureDevOpsPullRequest(w http.ResponseWriter, r *http.Request, event *types.AzureDevOpsPullRequestEvent) {
+	pr := event.Resource
+	logger.LogWebhook("pullrequest", event.EventType, event)
+
+	if pr.Status != "active" {
+		logger.LogInfo("Skipping PR #%d: status is %s", pr.PullRequestID, pr.Status)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	input := policy.PullRequest{
+		Draft:        pr.IsDraft,
+		Title:        pr.Title,
+		TargetBranch: strings.TrimPrefix(pr.TargetRefName, "refs/heads/"),
+		Author:       pr.CreatedBy.UniqueName,
+		DiffLines:    -1,
+	}
+	for _, label := range pr.Labels {
+		if label.Active {
+			input.Labels = append(input.Labels, label.Name)
+		}
+	}
+	if ok, reason := reviewPolicy.Evaluate(input); !ok {
+		logger.LogInfo("Skipping PR #%d: %s", pr.PullRequestID, reason)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Updates also fire for votes, reviewers and status changes; those keep
+	// the head commit and are skipped here
+	headSHA := pr.LastMergeSourceCommit.CommitID
+	if !dedupStore.BeginReview(requestHost(r), event.Repo(), pr.PullRequestID, headSHA) {
+		logger.LogInfo("Skipping PR #%d: review for %s already running or done", pr.PullRequestID, headSHA)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Queue PR for review
+	job := &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: pr.PullRequestID,
+		Repo:     event.Repo(),
+		HeadSHA:  headSHA,
+		Title:    pr.Title,
+		URL:      fmt.Sprintf("%s/pullrequest/%d", pr.Repository.RemoteURL, pr.PullRequestID),
+	}
+	if err := reviewQueue.Enqueue(job); err != nil {
+		logger.LogError("Failed to queue PR review", err)
+		dedupStore.AbandonReview(job.Provider, job.Repo, job.PRNumber, job.HeadSHA)
+		dedupStore.ForgetDelivery(deliveryID(r))
+		http.Error(w, "Failed to queue review", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
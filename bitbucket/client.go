@@ -0,0 +1,583 @@
+package bitbucket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	diffutil "pr-agent-reviewer/diff"
+	"pr-agent-reviewer/httpclient"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/sticky"
+	"pr-agent-reviewer/types"
+)
+
+// Client represents a Bitbucket Cloud client
+type Client struct {
+	baseURL     string
+	token       string
+	username    string
+	appPassword string
+	httpClient  *http.Client
+
+	// uuid is the bot's own account UUID, looked up once
+	uuidMu sync.Mutex
+	uuid   string
+}
+
+// NewClient creates a new Bitbucket Cloud client. It authenticates with
+// BITBUCKET_TOKEN (a repository, project or workspace access token) or with
+// BITBUCKET_USERNAME and BITBUCKET_APP_PASSWORD.
+func NewClient() *Client {
+	token := os.Getenv("BITBUCKET_TOKEN")
+	username := os.Getenv("BITBUCKET_USERNAME")
+	appPassword := os.Getenv("BITBUCKET_APP_PASSWORD")
+	if token == "" && (username == "" || appPassword == "") {
+		logger.LogError("BITBUCKET_TOKEN or BITBUCKET_USERNAME and BITBUCKET_APP_PASSWORD environment variables are not set", nil)
+		return nil
+	}
+
+	baseURL := os.Getenv("BITBUCKET_API_URL")
+	if baseURL == "" {
+		baseURL = "https://api.bitbucket.org/2.0"
+	}
+
+	return &Client{
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		token:       token,
+		username:    username,
+		appPassword: appPassword,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// apiComment is a pull request comment as returned by the API
+type apiComment struct {
+	ID      int64 `json:"id"`
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+	User struct {
+		UUID     string `json:"uuid"`
+		Nickname string `json:"nickname"`
+	} `json:"user"`
+	Parent *struct {
+		ID int64 `json:"id"`
+	} `json:"parent"`
+	Inline *struct {
+		Path string `json:"path"`
+		To   int    `json:"to"`
+	} `json:"inline"`
+	Deleted bool `json:"deleted"`
+}
+
+// diffStat is a changed file as returned by the diffstat API
+type diffStat struct {
+	Status       string `json:"status"`
+	LinesAdded   int    `json:"lines_added"`
+	LinesRemoved int    `json:"lines_removed"`
+	Old          *struct {
+		Path string `json:"path"`
+	} `json:"old"`
+	New *struct {
+		Path string `json:"path"`
+	} `json:"new"`
+}
+
+// GetChanges implements the vcs.Provider interface
+func (c *Client) GetChanges(ctx context.Context, repo string, prNumber int) ([]types.FileChange, error) {
+	logger.LogInfo("Getting changes for PR #%d in %s", prNumber, repo)
+
+	prPath := fmt.Sprintf("%s/pullrequests/%d", repoPath(repo), prNumber)
+	return c.changes(ctx, prPath+"/diffstat", prPath+"/diff")
+}
+
+// GetChangesBetween implements the vcs.Provider interface
+func (c *Client) GetChangesBetween(ctx context.Context, repo string, prNumber int, fromSHA, toSHA string) ([]types.FileChange, error) {
+	logger.LogInfo("Getting changes for PR #%d in %s between %s and %s", prNumber, repo, fromSHA, toSHA)
+
+	// Bitbucket specs read "A..B" as the changes in A since its merge base
+	// with B
+	spec := url.PathEscape(toSHA + ".." + fromSHA)
+	return c.changes(ctx, repoPath(repo)+"/diffstat/"+spec, repoPath(repo)+"/diff/"+spec)
+}
+
+// GetHeadSHA implements the vcs.Provider interface
+func (c *Client) GetHeadSHA(ctx context.Context, repo string, prNumber int) (string, error) {
+	var pr struct {
+		Source struct {
+			Commit struct {
+				Hash string `json:"hash"`
+			} `json:"commit"`
+		} `json:"source"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/pullrequests/%d", repoPath(repo), prNumber), nil, &pr); err != nil {
+		logger.LogError("Failed to get PR details", err)
+		return "", fmt.Errorf("failed to get PR details: %w", err)
+	}
+	return pr.Source.Commit.Hash, nil
+}
+
+// changes combines a diffstat with the matching diff into one change per file
+func (c *Client) changes(ctx context.Context, diffstatPath, diffPath string) ([]types.FileChange, error) {
+	var stats []diffStat
+	if err := c.list(ctx, diffstatPath, func(data json.RawMessage) error {
+		var page []diffStat
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		stats = append(stats, page...)
+		return nil
+	}); err != nil {
+		logger.LogError("Failed to get PR diffstat", err)
+		return nil, fmt.Errorf("failed to get PR diffstat: %w", err)
+	}
+
+	text, err := c.getText(ctx, diffPath)
+	if err != nil {
+		logger.LogError("Failed to get PR diff", err)
+		return nil, fmt.Errorf("failed to get PR diff: %w", err)
+	}
+
+	patches := make(map[string]diffutil.FilePatch)
+	for _, file := range diffutil.SplitFiles(text) {
+		patches[file.Path] = file
+	}
+
+	changes := make([]types.FileChange, 0, len(stats))
+	for _, stat := range stats {
+		path := ""
+		switch {
+		case stat.New != nil:
+			path = stat.New.Path
+		case stat.Old != nil:
+			path = stat.Old.Path
+		}
+
+		change := diffutil.NewFileChange(path, "")
+		if patch, ok := patches[path]; ok {
+			change = patch.Change()
+		}
+		change.Additions, change.Deletions = stat.LinesAdded, stat.LinesRemoved
+		switch stat.Status {
+		case "added":
+			change.Status = types.FileAdded
+		case "removed":
+			change.Status = types.FileRemoved
+		case "renamed":
+			change.Status = types.FileRenamed
+			if stat.Old != nil {
+				change.OldPath = stat.Old.Path
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// CreateReview implements the vcs.Provider interface
+func (c *Client) CreateReview(ctx context.Context, repo string, prNumber int, review *types.Review) error {
+	logger.LogInfo("Creating review for PR #%d in %s", prNumber, repo)
+
+	// Every comment is tagged with the review ID, so that a retry after a
+	// partial failure posts only the comments that are missing
+	posted, err := c.postedParts(ctx, repo, prNumber, review.ID)
+	if err != nil {
+		return err
+	}
+
+	if !posted[partReview] {
+		if err := c.createComment(ctx, repo, prNumber, map[string]interface{}{
+			"content": map[string]string{"raw": sticky.Tag(review.Body, review.ID, partReview)},
+		}); err != nil {
+			logger.LogError("Failed to create PR review", err)
+			return fmt.Errorf("failed to create PR review: %w", err)
+		}
+	}
+
+	// The review itself is posted, so findings that cannot be posted inline
+	// are collected in a follow-up comment rather than failing it
+	var rejected []types.Finding
+	for _, finding := range review.Findings {
+		part := findingPart(finding)
+		if posted[part] {
+			continue
+		}
+		body := sticky.Tag(finding.CommentBody(""), review.ID, part)
+		if err := c.CreateInlineComment(ctx, repo, prNumber, finding.File, finding.Line, body); err != nil {
+			rejected = append(rejected, finding)
+		}
+	}
+	if len(rejected) > 0 && !posted[partFindings] {
+		body := strings.TrimSpace(types.FormatFindings("Findings", rejected))
+		if err := c.createComment(ctx, repo, prNumber, map[string]interface{}{
+			"content": map[string]string{"raw": sticky.Tag(body, review.ID, partFindings)},
+		}); err != nil {
+			logger.LogError("Failed to post findings that could not be anchored", err)
+		}
+	}
+
+	c.submitVerdict(ctx, repo, prNumber, review.Verdict)
+	return nil
+}
+
+// Parts of a review that are posted as PR-level comments
+const (
+	partReview   = "review"
+	partFindings = "findings"
+)
+
+// findingPart names the inline comment of a finding within its review
+func findingPart(finding types.Finding) string {
+	return fmt.Sprintf("%s:%d", finding.File, finding.Line)
+}
+
+// postedParts returns the parts of a review that the bot has already posted
+// on the PR
+func (c *Client) postedParts(ctx context.Context, repo string, prNumber int, reviewID string) (map[string]bool, error) {
+	uuid, err := c.botUUID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	posted := make(map[string]bool)
+	path := fmt.Sprintf("%s/pullrequests/%d/comments?pagelen=100", repoPath(repo), prNumber)
+	if err := c.list(ctx, path, func(data json.RawMessage) error {
+		var page []apiComment
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		for _, comment := range page {
+			if comment.Deleted || comment.User.UUID != uuid {
+				continue
+			}
+			if part, ok := sticky.Tagged(comment.Content.Raw, reviewID); ok {
+				posted[part] = true
+			}
+		}
+		return nil
+	}); err != nil {
+		logger.LogError("Failed to list PR comments", err)
+		return nil, fmt.Errorf("failed to list PR comments: %w", err)
+	}
+	return posted, nil
+}
+
+// submitVerdict approves the PR or requests changes. A plain comment
+// withdraws both, so that a verdict on an earlier head does not outlive it.
+// Failures are logged, since the review itself is already posted.
+func (c *Client) submitVerdict(ctx context.Context, repo string, prNumber int, verdict types.Verdict) {
+	prPath := fmt.Sprintf("%s/pullrequests/%d", repoPath(repo), prNumber)
+
+	// Approving and requesting changes exclude each other, so the other one
+	// is withdrawn first. It fails harmlessly when it was not set.
+	var action, opposite string
+	switch verdict {
+	case types.VerdictApprove:
+		action, opposite = "approve", "request-changes"
+	case types.VerdictRequestChanges:
+		action, opposite = "request-changes", "approve"
+	default:
+		logger.LogInfo("Withdrawing verdict on PR #%d in %s", prNumber, repo)
+		c.do(ctx, http.MethodDelete, prPath+"/approve", nil, nil)
+		c.do(ctx, http.MethodDelete, prPath+"/request-changes", nil, nil)
+		return
+	}
+
+	c.do(ctx, http.MethodDelete, prPath+"/"+opposite, nil, nil)
+
+	logger.LogInfo("Submitting %s on PR #%d in %s", action, prNumber, repo)
+	if err := c.do(ctx, http.MethodPost, prPath+"/"+action, nil, nil); err != nil {
+		logger.LogError("Failed to submit PR verdict", err)
+	}
+}
+
+// CreateInlineComment posts a comment on a line of the new version of a file
+func (c *Client) CreateInlineComment(ctx context.Context, repo string, prNumber int, path string, line int, body string) error {
+	logger.LogInfo("Creating inline comment on %s:%d for PR #%d in %s", path, line, prNumber, repo)
+
+	if err := c.createComment(ctx, repo, prNumber, map[string]interface{}{
+		"content": map[string]string{"raw": body},
+		"inline":  map[string]interface{}{"path": path, "to": line},
+	}); err != nil {
+		logger.LogError("Failed to create inline comment", err)
+		return fmt.Errorf("failed to create inline comment: %w", err)
+	}
+	return nil
+}
+
+// PostComment implements the vcs.Provider interface. The thread ID is the ID
+// of the comment to reply to.
+func (c *Client) PostComment(ctx context.Context, repo string, prNumber int, threadID, body string) error {
+	payload := map[string]interface{}{
+		"content": map[string]string{"raw": body},
+	}
+	if threadID != "" {
+		parentID, err := strconv.ParseInt(threadID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid comment ID: %s", threadID)
+		}
+		payload["parent"] = map[string]int64{"id": parentID}
+		logger.LogInfo("Replying to comment %d on PR #%d in %s", parentID, prNumber, repo)
+	} else {
+		logger.LogInfo("Posting comment on PR #%d in %s", prNumber, repo)
+	}
+
+	if err := c.createComment(ctx, repo, prNumber, payload); err != nil {
+		logger.LogError("Failed to post PR comment", err)
+		return fmt.Errorf("failed to post PR comment: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) createComment(ctx context.Context, repo string, prNumber int, payload interface{}) error {
+	path := fmt.Sprintf("%s/pullrequests/%d/comments", repoPath(repo), prNumber)
+	return c.do(ctx, http.MethodPost, path, payload, nil)
+}
+
+// GetThread implements the vcs.Provider interface. The thread ID is the ID of
+// any comment in the thread; the thread is rebuilt from its top comment, and
+// replies to replies are included.
+func (c *Client) GetThread(ctx context.Context, repo string, prNumber int, threadID string) (*types.ReviewThread, error) {
+	rootID, err := strconv.ParseInt(threadID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid comment ID: %s", threadID)
+	}
+
+	logger.LogInfo("Getting comment thread %d on PR #%d in %s", rootID, prNumber, repo)
+
+	uuid, err := c.botUUID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*apiComment)
+	path := fmt.Sprintf("%s/pullrequests/%d/comments?pagelen=100", repoPath(repo), prNumber)
+	if err := c.list(ctx, path, func(data json.RawMessage) error {
+		var page []*apiComment
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		for _, comment := range page {
+			byID[comment.ID] = comment
+		}
+		return nil
+	}); err != nil {
+		logger.LogError("Failed to list PR comments", err)
+		return nil, fmt.Errorf("failed to list PR comments: %w", err)
+	}
+
+	root, ok := byID[rootID]
+	if !ok {
+		return nil, fmt.Errorf("comment %d not found on PR #%d", rootID, prNumber)
+	}
+	if top, ok := byID[threadRoot(byID, root)]; ok {
+		root, rootID = top, top.ID
+	}
+
+	var members []*apiComment
+	for _, comment := range byID {
+		if !comment.Deleted && threadRoot(byID, comment) == rootID {
+			members = append(members, comment)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+
+	thread := &types.ReviewThread{ID: threadID}
+	for _, comment := range members {
+		thread.Comments = append(thread.Comments, types.ThreadComment{
+			Author: comment.User.Nickname,
+			Body:   comment.Content.Raw,
+			Own:    comment.User.UUID == uuid,
+		})
+	}
+
+	if root.Inline != nil && root.Inline.Path != "" {
+		thread.File = root.Inline.Path
+		text, err := c.getText(ctx, fmt.Sprintf("%s/pullrequests/%d/diff", repoPath(repo), prNumber))
+		if err != nil {
+			logger.LogError("Failed to get PR diff", err)
+			return nil, fmt.Errorf("failed to get PR diff: %w", err)
+		}
+		for _, file := range diffutil.SplitFiles(text) {
+			if file.Path == root.Inline.Path {
+				thread.DiffHunk = diffutil.HunkAt(file.Patch, root.Inline.To)
+				break
+			}
+		}
+	}
+
+	return thread, nil
+}
+
+// threadRoot follows the parents of a comment up to the one that started its
+// thread
+func threadRoot(byID map[int64]*apiComment, c *apiComment) int64 {
+	for c.Parent != nil {
+		parent, ok := byID[c.Parent.ID]
+		if !ok {
+			return c.Parent.ID
+		}
+		c = parent
+	}
+	return c.ID
+}
+
+// IsCollaborator implements the vcs.Provider interface. Bitbucket identifies
+// users by account UUID, so username is the UUID of the user, e.g.
+// "{a1b2c3...}". Users need write or admin access to the repository.
+func (c *Client) IsCollaborator(ctx context.Context, repo, username string) (bool, error) {
+	workspace, slug, ok := strings.Cut(repo, "/")
+	if !ok {
+		return false, fmt.Errorf("invalid repository format: %s", repo)
+	}
+
+	path := fmt.Sprintf("/workspaces/%s/permissions/repositories/%s?q=%s",
+		url.PathEscape(workspace), url.PathEscape(slug), url.QueryEscape(fmt.Sprintf("user.uuid=%q", username)))
+
+	var result struct {
+		Values []struct {
+			Permission string `json:"permission"`
+		} `json:"values"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		logger.LogError("Failed to check repository permission", err)
+		return false, fmt.Errorf("failed to check repository permission: %w", err)
+	}
+
+	for _, value := range result.Values {
+		if value.Permission == "write" || value.Permission == "admin" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// botUUID returns the account UUID the client posts as
+func (c *Client) botUUID(ctx context.Context) (string, error) {
+	c.uuidMu.Lock()
+	defer c.uuidMu.Unlock()
+	if c.uuid != "" {
+		return c.uuid, nil
+	}
+
+	var user struct {
+		UUID string `json:"uuid"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/user", nil, &user); err != nil {
+		logger.LogError("Failed to get current user", err)
+		return "", fmt.Errorf("failed to get current user: %w", err)
+	}
+	c.uuid = user.UUID
+	return c.uuid, nil
+}
+
+// list walks the pages of a paginated API response, calling fn with the
+// values of each page
+func (c *Client) list(ctx context.Context, path string, fn func(json.RawMessage) error) error {
+	for path != "" {
+		var page struct {
+			Values json.RawMessage `json:"values"`
+			Next   string          `json:"next"`
+		}
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return err
+		}
+		if err := fn(page.Values); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		path = page.Next
+	}
+	return nil
+}
+
+// do sends a JSON request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// getText fetches a plain-text resource such as a diff
+func (c *Client) getText(ctx context.Context, path string) (string, error) {
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return string(data), nil
+}
+
+// send sends an authenticated request. Paths may be relative to the API URL
+// or absolute, as in the next links of paginated responses.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	target := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		target = c.baseURL + path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else {
+		req.SetBasicAuth(c.username, c.appPassword)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &httpclient.StatusError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	return resp, nil
+}
+
+// repoPath returns the API path of a "workspace/repo_slug" repository
+func repoPath(repo string) string {
+	workspace, slug, _ := strings.Cut(repo, "/")
+	return "/repositories/" + url.PathEscape(workspace) + "/" + url.PathEscape(slug)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"pr-agent-reviewer/command"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/policy"
+	"pr-agent-reviewer/queue"
+	"pr-agent-reviewer/types"
+	"pr-agent-reviewer/webhook"
+)
+
+// bitbucketEvents routes Bitbucket webhooks by their X-Event-Key header
+var bitbucketEvents = webhook.NewDispatcher("Bitbucket")
+
+func init() {
+	bitbucketEvents.Register("pullrequest:created", webhook.Typed(handleBitbucketPullRequest))
+	bitbucketEvents.Register("pullrequest:updated", webhook.Typed(handleBitbucketPullRequest))
+	bitbucketEvents.Register("pullrequest:comment_created", webhook.Typed(handleBitbucketComment))
+}
+
+func handleBitbucketWebhook(w http.ResponseWriter, r *http.Request, body []byte) {
+	bitbucketEvents.Dispatch(w, r, r.Header.Get("X-Event-Key"), body)
+}
+
+func handleBitbucketPullRequest(w http.ResponseWriter, r *http.Request, event *types.BitbucketPullRequestEvent) {
+	pr := event.PullRequest
+	logger.LogWebhook("pullrequest", r.Header.Get("X-Event-Key"), event)
+
+	if pr.State != "OPEN" {
+		logger.LogInfo("Skipping PR #%d: state is %s", pr.ID, pr.State)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Bitbucket has no labels and the payload does not carry the diff size,
+	// so size limits are checked once the changes are fetched
+	if ok, reason := reviewPolicy.Evaluate(policy.PullRequest{
+		Draft:        pr.Draft,
+		Title:        pr.Title,
+		TargetBranch: pr.Destination.Branch.Name,
+		Author:       pr.Author.Nickname,
+		DiffLines:    -1,
+	}); !ok {
+		logger.LogInfo("Skipping PR #%d: %s", pr.ID, reason)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Updates also fire for title and description edits; those keep the
+	// head SHA and are skipped here
+	if !dedupStore.BeginReview(requestHost(r), event.Repository.FullName, pr.ID, pr.Source.Commit.Hash) {
+		logger.LogInfo("Skipping PR #%d: review for %s already running or done", pr.ID, pr.Source.Commit.Hash)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Queue PR for review
+	job := &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: pr.ID,
+		Repo:     event.Repository.FullName,
+		HeadSHA:  pr.Source.Commit.Hash,
+		Title:    pr.Title,
+		URL:      pr.Links.HTML.Href,
+	}
+	if err := reviewQueue.Enqueue(job); err != nil {
+		logger.LogError("Failed to queue PR review", err)
+		dedupStore.AbandonReview(job.Provider, job.Repo, job.PRNumber, job.HeadSHA)
+		dedupStore.ForgetDelivery(deliveryID(r))
+		http.Error(w, "Failed to queue review", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleBitbucketComment(w http.ResponseWriter, r *http.Request, event *types.BitbucketCommentEvent) {
+	logger.LogWebhook("pullrequest", "comment_created", event)
+
+	if botName := os.Getenv("BITBUCKET_BOT_USERNAME"); botName != "" && event.Actor.Nickname == botName {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Commands are answered in a thread under the comment; other replies
+	// may continue a thread the bot started. Bitbucket identifies users by
+	// UUID, which is what the permission check expects.
+	threadID := strconv.FormatInt(event.Comment.ID, 10)
+	cmd, ok := command.Parse(event.Comment.Content.Raw)
+	if !ok {
+		if event.Comment.Parent == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		cmd = &command.Command{Action: command.ActionReply}
+		threadID = strconv.FormatInt(event.Comment.Parent.ID, 10)
+	}
+
+	enqueueCommand(w, r, cmd, &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: event.PullRequest.ID,
+		Repo:     event.Repository.FullName,
+		Title:    event.PullRequest.Title,
+		URL:      event.PullRequest.Links.HTML.Href,
+		ThreadID: threadID,
+		Author:   event.Actor.UUID,
+	})
+}
@@ -0,0 +1,76 @@
+package command
+
+import (
+	"strings"
+)
+
+// Prefix starts every bot command in a PR/MR comment
+const Prefix = "/ai-review"
+
+// Action represents what a command asks the bot to do
+type Action string
+
+const (
+	// ActionReview runs a full review of the pull/merge request
+	ActionReview Action = "review"
+	// ActionSummarize summarizes the changes
+	ActionSummarize Action = "summarize"
+	// ActionSecurity runs a security-focused review
+	ActionSecurity Action = "security"
+	// ActionAsk answers a question about the changes
+	ActionAsk Action = "ask"
+	// ActionHelp lists the available commands
+	ActionHelp Action = "help"
+	// ActionReply answers a reply in a review thread started by the bot. It
+	// is queued for thread replies and never parsed from a comment.
+	ActionReply Action = "reply"
+)
+
+// Command is a bot command parsed from a comment
+type Command struct {
+	Action Action
+	// Args holds the text following the action, e.g. the question to ask
+	Args string
+}
+
+// Help describes the available commands. Commands are wrapped in code spans
+// so that the help text itself is never parsed as a command.
+const Help = "Available commands:\n" +
+	"- `" + Prefix + "`: run a full review\n" +
+	"- `" + Prefix + " summarize`: summarize the changes\n" +
+	"- `" + Prefix + " security`: run a security-focused review\n" +
+	"- `" + Prefix + " ask <question>`: ask a question about the changes"
+
+// Parse looks for a command on its own line in a comment body. It returns
+// false when the comment contains no command. Unknown actions and an ask
+// without a question parse as ActionHelp.
+func Parse(body string) (*Command, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line != Prefix && !strings.HasPrefix(line, Prefix+" ") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, Prefix))
+		if len(fields) == 0 {
+			return &Command{Action: ActionReview}, true
+		}
+
+		action := Action(strings.ToLower(fields[0]))
+		args := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(line, Prefix)), fields[0]))
+
+		switch action {
+		case ActionReview, ActionSummarize, ActionSecurity:
+			return &Command{Action: action}, true
+		case ActionAsk:
+			if args == "" {
+				return &Command{Action: ActionHelp}, true
+			}
+			return &Command{Action: ActionAsk, Args: args}, true
+		default:
+			return &Command{Action: ActionHelp}, true
+		}
+	}
+
+	return nil, false
+}
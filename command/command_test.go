@@ -0,0 +1,87 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   *Command
+		wantOK bool
+	}{
+		{
+			name:   "no command",
+			body:   "Looks good to me",
+			wantOK: false,
+		},
+		{
+			name:   "bare prefix runs a review",
+			body:   "/ai-review",
+			want:   &Command{Action: ActionReview},
+			wantOK: true,
+		},
+		{
+			name:   "command on its own line",
+			body:   "Thanks!\n  /ai-review summarize  \nCheers",
+			want:   &Command{Action: ActionSummarize},
+			wantOK: true,
+		},
+		{
+			name:   "action is case insensitive",
+			body:   "/ai-review SECURITY",
+			want:   &Command{Action: ActionSecurity},
+			wantOK: true,
+		},
+		{
+			name:   "ask with a question",
+			body:   "/ai-review ask Why is the cache   unbounded?",
+			want:   &Command{Action: ActionAsk, Args: "Why is the cache   unbounded?"},
+			wantOK: true,
+		},
+		{
+			name:   "ask without a question",
+			body:   "/ai-review ask",
+			want:   &Command{Action: ActionHelp},
+			wantOK: true,
+		},
+		{
+			name:   "unknown action",
+			body:   "/ai-review deploy",
+			want:   &Command{Action: ActionHelp},
+			wantOK: true,
+		},
+		{
+			name:   "reply is not a command",
+			body:   "/ai-review reply",
+			want:   &Command{Action: ActionHelp},
+			wantOK: true,
+		},
+		{
+			name:   "prefix inside a line",
+			body:   "Please run /ai-review later",
+			wantOK: false,
+		},
+		{
+			name:   "prefix followed by other text",
+			body:   "/ai-reviewer summarize",
+			wantOK: false,
+		},
+		{
+			name:   "help text",
+			body:   Help,
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Parse(tt.body)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, %t, want %+v, %t", tt.body, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"pr-agent-reviewer/command"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/queue"
+	"pr-agent-reviewer/store"
+	"pr-agent-reviewer/types"
+	"pr-agent-reviewer/vcs"
+)
+
+// enqueueCommand queues a command parsed from a PR/MR comment. The permission
+// check runs in the worker so that webhooks are acknowledged quickly.
+func enqueueCommand(w http.ResponseWriter, r *http.Request, cmd *command.Command, job *queue.Job) {
+	job.Command = string(cmd.Action)
+	job.Args = cmd.Args
+
+	logger.LogInfo("Received %s command from %s on PR #%d in %s", job.Command, job.Author, job.PRNumber, job.Repo)
+	if err := reviewQueue.Enqueue(job); err != nil {
+		logger.LogError("Failed to queue command", err)
+		dedupStore.ForgetDelivery(deliveryID(r))
+		http.Error(w, "Failed to queue command", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// runCommand runs a command from a PR/MR comment and replies in the thread it
+// came from. Commands from users who cannot write to the repository are
+// ignored.
+func runCommand(ctx context.Context, job *queue.Job, run *store.Run) error {
+	prNumber, repo := job.PRNumber, job.Repo
+	logger.LogInfo("Running %s command on PR #%d in %s", job.Command, prNumber, repo)
+
+	vcsProvider, err := providerFor(job)
+	if err != nil {
+		return err
+	}
+
+	if job.Review == "" {
+		var allowed bool
+		err := runStage(ctx, run, "check permission", func() error {
+			var err error
+			allowed, err = vcsProvider.IsCollaborator(ctx, repo, job.Author)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			logger.LogInfo("Ignoring %s command from %s on PR #%d in %s: not a collaborator", job.Command, job.Author, prNumber, repo)
+			return nil
+		}
+
+		if command.Action(job.Command) == command.ActionReview {
+			// Full reviews go through the regular review pipeline, pinned to
+			// the current head so that they are recorded as its review and
+			// are superseded by newer commits. A head that is reviewed
+			// already is reviewed again, unless its review is still running.
+			var headSHA string
+			err := runStage(ctx, run, "get head", func() error {
+				var err error
+				headSHA, err = vcsProvider.GetHeadSHA(ctx, repo, prNumber)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if !dedupStore.ForceReview(job.Provider, repo, prNumber, headSHA) {
+				logger.LogInfo("Skipping review command on PR #%d in %s: review for %s already running", prNumber, repo, headSHA)
+				return nil
+			}
+			err = reviewQueue.Enqueue(&queue.Job{
+				Provider: job.Provider,
+				PRNumber: prNumber,
+				Repo:     repo,
+				HeadSHA:  headSHA,
+				Title:    job.Title,
+				URL:      job.URL,
+				Forced:   true,
+
+				InstallationID: job.InstallationID,
+			})
+			if err != nil {
+				dedupStore.AbandonReview(job.Provider, repo, prNumber, headSHA)
+			}
+			return err
+		}
+
+		job.Review, err = commandReply(ctx, vcsProvider, job, run)
+		if err != nil {
+			return err
+		}
+		if job.Review == "" {
+			logger.LogInfo("Nothing to reply to for %s command on PR #%d in %s", job.Command, prNumber, repo)
+			return nil
+		}
+		checkpoint(job)
+	}
+
+	if !job.ReviewPosted {
+		err := runStage(ctx, run, "post reply", func() error {
+			return vcsProvider.PostComment(ctx, repo, prNumber, job.ThreadID, job.Review)
+		})
+		if err != nil {
+			return err
+		}
+		job.ReviewPosted = true
+		checkpoint(job)
+	}
+
+	logger.LogInfo("Completed %s command on PR #%d in %s", job.Command, prNumber, repo)
+	return nil
+}
+
+// commandReply generates the reply to a command. An empty reply means there
+// is nothing to answer.
+func commandReply(ctx context.Context, vcsProvider vcs.Provider, job *queue.Job, run *store.Run) (string, error) {
+	action := command.Action(job.Command)
+	switch action {
+	case command.ActionHelp:
+		return command.Help, nil
+	case command.ActionReply:
+		return threadReply(ctx, vcsProvider, job, run)
+	}
+
+	var changes []types.FileChange
+	err := runStage(ctx, run, "get changes", func() error {
+		var err error
+		changes, err = vcsProvider.GetChanges(ctx, job.Repo, job.PRNumber)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var reply string
+	err = runStage(ctx, run, "run "+job.Command, func() error {
+		var err error
+		switch action {
+		case command.ActionSummarize:
+			reply, err = aiProvider.SummarizeChanges(ctx, changes)
+		case command.ActionSecurity:
+			reply, err = aiProvider.SecurityReview(ctx, changes)
+		default:
+			reply, err = aiProvider.AnswerQuestion(ctx, changes, job.Args)
+		}
+		return err
+	})
+	return reply, err
+}
+
+// threadReply answers the latest comment in a review thread. Threads the bot
+// did not start, or whose latest comment is the bot's own, get no reply.
+func threadReply(ctx context.Context, vcsProvider vcs.Provider, job *queue.Job, run *store.Run) (string, error) {
+	var thread *types.ReviewThread
+	err := runStage(ctx, run, "get thread", func() error {
+		var err error
+		thread, err = vcsProvider.GetThread(ctx, job.Repo, job.PRNumber, job.ThreadID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if !thread.StartedByBot() || !thread.AwaitingReply() {
+		return "", nil
+	}
+
+	// Include the file's current diff so that "fixed, please re-check" can be
+	// verified against the latest push
+	var currentDiff string
+	if thread.File != "" {
+		var changes []types.FileChange
+		err := runStage(ctx, run, "get changes", func() error {
+			var err error
+			changes, err = vcsProvider.GetChanges(ctx, job.Repo, job.PRNumber)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		currentDiff = fileDiff(changes, thread.File)
+	}
+
+	var reply string
+	err = runStage(ctx, run, "reply to thread", func() error {
+		var err error
+		reply, err = aiProvider.ReplyToThread(ctx, thread, currentDiff)
+		return err
+	})
+	return reply, err
+}
+
+// fileDiff returns the patch of one file from a list of changes
+func fileDiff(changes []types.FileChange, file string) string {
+	for _, change := range changes {
+		if change.Path == file {
+			return change.Patch
+		}
+	}
+	return ""
+}
@@ -0,0 +1,237 @@
+package dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"pr-agent-reviewer/logger"
+)
+
+// ReviewStatus represents the state of a review for a given head SHA
+type ReviewStatus string
+
+const (
+	// ReviewRunning means the review is queued or being processed
+	ReviewRunning ReviewStatus = "running"
+	// ReviewDone means the review was posted
+	ReviewDone ReviewStatus = "done"
+)
+
+type reviewRecord struct {
+	Status    ReviewStatus `json:"status"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+type lastReviewed struct {
+	SHA        string    `json:"sha"`
+	ReviewedAt time.Time `json:"reviewed_at"`
+}
+
+type state struct {
+	Deliveries   map[string]time.Time     `json:"deliveries"`
+	Reviews      map[string]*reviewRecord `json:"reviews"`
+	LastReviewed map[string]*lastReviewed `json:"last_reviewed"`
+}
+
+// Store remembers webhook delivery IDs and reviewed head SHAs so that
+// redelivered webhooks do not trigger duplicate reviews, and the last
+// reviewed head of every PR so that new pushes can be reviewed
+// incrementally. Entries older than the configured window are forgotten.
+type Store struct {
+	path   string
+	window time.Duration
+
+	mu    sync.Mutex
+	state state
+}
+
+// NewStore creates a new store based on the configuration and loads the
+// state persisted by a previous run
+func NewStore() (*Store, error) {
+	path := os.Getenv("DEDUP_STATE_FILE")
+	if path == "" {
+		path = filepath.Join("data", "dedup.json")
+	}
+
+	window, err := time.ParseDuration(os.Getenv("DEDUP_WINDOW"))
+	if err != nil || window <= 0 {
+		window = 72 * time.Hour
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create dedup state directory: %v", err)
+	}
+
+	s := &Store{
+		path:   path,
+		window: window,
+		state: state{
+			Deliveries: make(map[string]time.Time),
+			Reviews:    make(map[string]*reviewRecord),
+		},
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read dedup state: %v", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			return nil, fmt.Errorf("failed to decode dedup state: %v", err)
+		}
+	}
+	if s.state.LastReviewed == nil {
+		s.state.LastReviewed = make(map[string]*lastReviewed)
+	}
+
+	logger.LogInfo("Initialized dedup store at %s (window: %v)", path, window)
+	return s, nil
+}
+
+func prKey(provider, repo string, prNumber int) string {
+	return fmt.Sprintf("%s:%s#%d", provider, repo, prNumber)
+}
+
+func reviewKey(provider, repo string, prNumber int, headSHA string) string {
+	return prKey(provider, repo, prNumber) + "@" + headSHA
+}
+
+// MarkDelivery records a delivery ID and reports whether it is new. Empty
+// IDs are always treated as new.
+func (s *Store) MarkDelivery(id string) bool {
+	if id == "" {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seen, ok := s.state.Deliveries[id]; ok && time.Since(seen) < s.window {
+		return false
+	}
+	s.state.Deliveries[id] = time.Now()
+	s.save()
+	return true
+}
+
+// ForgetDelivery removes a delivery ID so that a retried delivery is processed
+func (s *Store) ForgetDelivery(id string) {
+	if id == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.state.Deliveries, id)
+	s.save()
+}
+
+// BeginReview marks the review of a PR at a head SHA as running and reports
+// whether it should go ahead. It returns false when the same review is
+// already running or done.
+func (s *Store) BeginReview(provider, repo string, prNumber int, headSHA string) bool {
+	key := reviewKey(provider, repo, prNumber, headSHA)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.state.Reviews[key]; ok && time.Since(record.UpdatedAt) < s.window {
+		logger.LogInfo("Review %s is already %s", key, record.Status)
+		return false
+	}
+	s.state.Reviews[key] = &reviewRecord{Status: ReviewRunning, UpdatedAt: time.Now()}
+	s.save()
+	return true
+}
+
+// ForceReview marks a review requested explicitly as running. Unlike
+// BeginReview it repeats a review that is done, and only returns false when
+// the same review is already running.
+func (s *Store) ForceReview(provider, repo string, prNumber int, headSHA string) bool {
+	key := reviewKey(provider, repo, prNumber, headSHA)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.state.Reviews[key]; ok && record.Status == ReviewRunning && time.Since(record.UpdatedAt) < s.window {
+		logger.LogInfo("Review %s is already %s", key, record.Status)
+		return false
+	}
+	s.state.Reviews[key] = &reviewRecord{Status: ReviewRunning, UpdatedAt: time.Now()}
+	s.save()
+	return true
+}
+
+// CompleteReview marks a review as done and records its head SHA as the last
+// reviewed head of the PR
+func (s *Store) CompleteReview(provider, repo string, prNumber int, headSHA string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.state.Reviews[reviewKey(provider, repo, prNumber, headSHA)] = &reviewRecord{Status: ReviewDone, UpdatedAt: now}
+	s.state.LastReviewed[prKey(provider, repo, prNumber)] = &lastReviewed{SHA: headSHA, ReviewedAt: now}
+	s.save()
+}
+
+// AbandonReview forgets a review that did not complete so that it can be
+// triggered again
+func (s *Store) AbandonReview(provider, repo string, prNumber int, headSHA string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.state.Reviews, reviewKey(provider, repo, prNumber, headSHA))
+	s.save()
+}
+
+// LastReviewedSHA returns the head SHA of the last completed review of a PR,
+// or an empty string if the PR has not been reviewed
+func (s *Store) LastReviewedSHA(provider, repo string, prNumber int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.state.LastReviewed[prKey(provider, repo, prNumber)]; ok {
+		return last.SHA
+	}
+	return ""
+}
+
+// save prunes expired entries and writes the state to disk. The caller must
+// hold the lock.
+func (s *Store) save() {
+	for id, seen := range s.state.Deliveries {
+		if time.Since(seen) >= s.window {
+			delete(s.state.Deliveries, id)
+		}
+	}
+	for key, record := range s.state.Reviews {
+		if time.Since(record.UpdatedAt) >= s.window {
+			delete(s.state.Reviews, key)
+		}
+	}
+	for key, last := range s.state.LastReviewed {
+		if time.Since(last.ReviewedAt) >= s.window {
+			delete(s.state.LastReviewed, key)
+		}
+	}
+
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		logger.LogError("Failed to marshal dedup state", err)
+		return
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		logger.LogError("Failed to write dedup state", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		logger.LogError("Failed to persist dedup state", err)
+	}
+}
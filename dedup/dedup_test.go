@@ -0,0 +1,182 @@
+package dedup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestStore opens the store persisted at path
+func newTestStore(t *testing.T, path string, window time.Duration) *Store {
+	t.Helper()
+	t.Setenv("DEDUP_STATE_FILE", path)
+	t.Setenv("DEDUP_WINDOW", window.String())
+
+	s, err := NewStore()
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	return s
+}
+
+func TestMarkDelivery(t *testing.T) {
+	s := newTestStore(t, filepath.Join(t.TempDir(), "dedup.json"), time.Hour)
+
+	steps := []struct {
+		name   string
+		forget bool
+		id     string
+		want   bool
+	}{
+		{name: "new delivery", id: "delivery-1", want: true},
+		{name: "redelivery", id: "delivery-1", want: false},
+		{name: "other delivery", id: "delivery-2", want: true},
+		{name: "missing ID", id: "", want: true},
+		{name: "missing ID again", id: "", want: true},
+		{name: "forget the delivery", forget: true, id: "delivery-1"},
+		{name: "retried delivery", id: "delivery-1", want: true},
+	}
+
+	for _, step := range steps {
+		if step.forget {
+			s.ForgetDelivery(step.id)
+			continue
+		}
+		if got := s.MarkDelivery(step.id); got != step.want {
+			t.Errorf("%s: MarkDelivery(%q) = %t, want %t", step.name, step.id, got, step.want)
+		}
+	}
+}
+
+func TestReviewLifecycle(t *testing.T) {
+	tests := []struct {
+		name   string
+		before func(s *Store)
+		want   bool
+	}{
+		{name: "new review", before: func(s *Store) {}, want: true},
+		{name: "review running", before: func(s *Store) { s.BeginReview("github", "org/repo", 1, "abc123") }, want: false},
+		{name: "review done", before: func(s *Store) {
+			s.BeginReview("github", "org/repo", 1, "abc123")
+			s.CompleteReview("github", "org/repo", 1, "abc123")
+		}, want: false},
+		{name: "review abandoned", before: func(s *Store) {
+			s.BeginReview("github", "org/repo", 1, "abc123")
+			s.AbandonReview("github", "org/repo", 1, "abc123")
+		}, want: true},
+		{name: "other head SHA", before: func(s *Store) { s.CompleteReview("github", "org/repo", 1, "def456") }, want: true},
+		{name: "other PR", before: func(s *Store) { s.CompleteReview("github", "org/repo", 2, "abc123") }, want: true},
+		{name: "same PR on another provider", before: func(s *Store) { s.CompleteReview("gitlab", "org/repo", 1, "abc123") }, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, filepath.Join(t.TempDir(), "dedup.json"), time.Hour)
+			tt.before(s)
+			if got := s.BeginReview("github", "org/repo", 1, "abc123"); got != tt.want {
+				t.Errorf("BeginReview() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastReviewedSHA(t *testing.T) {
+	tests := []struct {
+		name   string
+		before func(s *Store)
+		want   string
+	}{
+		{name: "never reviewed", before: func(s *Store) {}, want: ""},
+		{name: "review still running", before: func(s *Store) { s.BeginReview("github", "org/repo", 1, "abc123") }, want: ""},
+		{name: "review done", before: func(s *Store) { s.CompleteReview("github", "org/repo", 1, "abc123") }, want: "abc123"},
+		{name: "latest review wins", before: func(s *Store) {
+			s.CompleteReview("github", "org/repo", 1, "abc123")
+			s.CompleteReview("github", "org/repo", 1, "def456")
+		}, want: "def456"},
+		{name: "abandoned review keeps the last head", before: func(s *Store) {
+			s.CompleteReview("github", "org/repo", 1, "abc123")
+			s.BeginReview("github", "org/repo", 1, "def456")
+			s.AbandonReview("github", "org/repo", 1, "def456")
+		}, want: "abc123"},
+		{name: "other PR", before: func(s *Store) { s.CompleteReview("github", "org/repo", 2, "abc123") }, want: ""},
+		{name: "same PR on another provider", before: func(s *Store) { s.CompleteReview("gitlab", "org/repo", 1, "abc123") }, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, filepath.Join(t.TempDir(), "dedup.json"), time.Hour)
+			tt.before(s)
+			if got := s.LastReviewedSHA("github", "org/repo", 1); got != tt.want {
+				t.Errorf("LastReviewedSHA() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorePersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "dedup.json")
+	s := newTestStore(t, path, time.Hour)
+	s.MarkDelivery("delivery-1")
+	s.BeginReview("github", "org/repo", 1, "abc123")
+	s.CompleteReview("github", "org/repo", 1, "abc123")
+
+	restarted := newTestStore(t, path, time.Hour)
+	if restarted.MarkDelivery("delivery-1") {
+		t.Error("MarkDelivery() after a restart treated a seen delivery as new")
+	}
+	if restarted.BeginReview("github", "org/repo", 1, "abc123") {
+		t.Error("BeginReview() after a restart repeated a completed review")
+	}
+	if got := restarted.LastReviewedSHA("github", "org/repo", 1); got != "abc123" {
+		t.Errorf("LastReviewedSHA() after a restart = %q, want %q", got, "abc123")
+	}
+}
+
+func TestStoreForgetsEntriesOutsideTheWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	s := newTestStore(t, path, 10*time.Millisecond)
+	s.MarkDelivery("delivery-1")
+	s.BeginReview("github", "org/repo", 1, "abc123")
+	s.CompleteReview("github", "org/repo", 1, "abc123")
+	time.Sleep(20 * time.Millisecond)
+
+	if !s.MarkDelivery("delivery-1") {
+		t.Error("MarkDelivery() remembered a delivery outside the window")
+	}
+	if !s.BeginReview("github", "org/repo", 1, "abc123") {
+		t.Error("BeginReview() remembered a review outside the window")
+	}
+	if got := s.LastReviewedSHA("github", "org/repo", 1); got != "" {
+		t.Errorf("LastReviewedSHA() = %q, want the head outside the window forgotten", got)
+	}
+}
+
+func TestForceReview(t *testing.T) {
+	tests := []struct {
+		name   string
+		before func(s *Store)
+		want   bool
+	}{
+		{name: "new review", before: func(s *Store) {}, want: true},
+		{name: "review running", before: func(s *Store) { s.BeginReview("github", "org/repo", 1, "abc123") }, want: false},
+		{name: "review done", before: func(s *Store) {
+			s.BeginReview("github", "org/repo", 1, "abc123")
+			s.CompleteReview("github", "org/repo", 1, "abc123")
+		}, want: true},
+		{name: "forced review running", before: func(s *Store) { s.ForceReview("github", "org/repo", 1, "abc123") }, want: false},
+		{name: "other head SHA running", before: func(s *Store) { s.BeginReview("github", "org/repo", 1, "def456") }, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, filepath.Join(t.TempDir(), "dedup.json"), time.Hour)
+			tt.before(s)
+			if got := s.ForceReview("github", "org/repo", 1, "abc123"); got != tt.want {
+				t.Errorf("ForceReview() = %t, want %t", got, tt.want)
+			}
+			if s.BeginReview("github", "org/repo", 1, "abc123") {
+				t.Error("BeginReview() after ForceReview() started the review again")
+			}
+		})
+	}
+}
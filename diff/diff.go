@@ -0,0 +1,449 @@
+package diff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pr-agent-reviewer/types"
+)
+
+// FilePatch is the patch of a single file in a unified diff
+type FilePatch struct {
+	Path string
+	// OldPath is the path before a rename, and the same as Path otherwise
+	OldPath string
+	Status  types.FileStatus
+	Binary  bool
+	// Patch holds the hunks of the file, starting at the first @@ header
+	Patch string
+}
+
+// Change returns the file change the patch describes
+func (f FilePatch) Change() types.FileChange {
+	change := NewFileChange(f.Path, f.Patch)
+	change.OldPath = f.OldPath
+	change.Status = f.Status
+	change.Binary = change.Binary || f.Binary
+	return change
+}
+
+// SplitFiles splits a multi-file unified diff, as produced by git diff, into
+// the patches of each file
+func SplitFiles(text string) []FilePatch {
+	var files []FilePatch
+	var current *FilePatch
+	var hunks []string
+
+	flush := func() {
+		if current != nil {
+			current.Patch = strings.Join(hunks, "\n")
+			if current.OldPath == "" {
+				current.OldPath = current.Path
+			}
+			files = append(files, *current)
+		}
+		hunks = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			current = &FilePatch{Path: gitHeaderPath(line), Status: types.FileModified}
+		case current == nil:
+		case len(hunks) > 0 || strings.HasPrefix(line, "@@"):
+			hunks = append(hunks, line)
+		case strings.HasPrefix(line, "new file mode"):
+			current.Status = types.FileAdded
+		case strings.HasPrefix(line, "deleted file mode"):
+			current.Status = types.FileRemoved
+		case strings.HasPrefix(line, "rename from "):
+			current.OldPath = strings.TrimPrefix(line, "rename from ")
+			current.Status = types.FileRenamed
+		case strings.HasPrefix(line, "rename to "):
+			current.Path = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+			current.Binary = true
+		case strings.HasPrefix(line, "--- "):
+			if path := strings.TrimPrefix(line, "--- "); path != "/dev/null" && current.Status != types.FileRenamed {
+				current.OldPath = strings.TrimPrefix(path, "a/")
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if path := strings.TrimPrefix(line, "+++ "); path != "/dev/null" {
+				current.Path = strings.TrimPrefix(path, "b/")
+			} else {
+				// Removed files keep their old path
+				current.Path = current.OldPath
+			}
+		}
+	}
+	flush()
+
+	return files
+}
+
+// NewFileChange returns the change of a file from its patch, with the hunks
+// parsed and the lines counted. Callers fill in what the patch does not show,
+// such as the status of the file.
+func NewFileChange(path, patch string) types.FileChange {
+	change := types.FileChange{
+		Path:     path,
+		OldPath:  path,
+		Status:   types.FileModified,
+		Language: Language(path),
+		Patch:    patch,
+		Hunks:    ParseHunks(patch),
+		Binary:   strings.HasPrefix(patch, "Binary files ") || strings.HasPrefix(patch, "GIT binary patch"),
+	}
+	for _, hunk := range change.Hunks {
+		for _, line := range hunk.Lines {
+			switch line.Kind {
+			case types.LineAdded:
+				change.Additions++
+			case types.LineRemoved:
+				change.Deletions++
+			}
+		}
+	}
+	return change
+}
+
+// ParseHunks parses the hunks of a patch, numbering their lines
+func ParseHunks(patch string) []types.Hunk {
+	var hunks []types.Hunk
+	var current *types.Hunk
+	oldLine, newLine := 0, 0
+	for _, text := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(text, "@@") {
+			hunks = append(hunks, types.Hunk{})
+			current = &hunks[len(hunks)-1]
+			current.OldStart, current.OldLines = hunkOldRange(text)
+			current.NewStart, current.NewLines = hunkRange(text)
+			if _, section, ok := strings.Cut(strings.TrimPrefix(text, "@@"), "@@"); ok {
+				current.Section = strings.TrimSpace(section)
+			}
+			oldLine, newLine = current.OldStart, current.NewStart
+			continue
+		}
+		if current == nil || text == "" {
+			continue
+		}
+
+		line := types.DiffLine{Kind: types.LineKind(text[0]), Text: text[1:]}
+		switch line.Kind {
+		case types.LineAdded:
+			line.NewLine = newLine
+			newLine++
+		case types.LineRemoved:
+			line.OldLine = oldLine
+			oldLine++
+		case types.LineContext:
+			line.OldLine, line.NewLine = oldLine, newLine
+			oldLine++
+			newLine++
+		default:
+			// "\ No newline at end of file" and the like
+			continue
+		}
+		current.Lines = append(current.Lines, line)
+	}
+	return hunks
+}
+
+// gitHeaderPath returns the new path from a "diff --git a/x b/x" line
+func gitHeaderPath(line string) string {
+	if i := strings.LastIndex(line, " b/"); i >= 0 {
+		return line[i+3:]
+	}
+	return strings.TrimPrefix(line, "diff --git ")
+}
+
+// HunkAt returns the hunk of a patch that covers a line of the new file, or
+// the whole patch when no hunk does
+func HunkAt(patch string, line int) string {
+	var hunk []string
+	found := false
+	for _, text := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(text, "@@") {
+			if found {
+				break
+			}
+			start, count := hunkRange(text)
+			found = line >= start && line < start+count
+			hunk = hunk[:0]
+		}
+		hunk = append(hunk, text)
+	}
+
+	if !found {
+		return patch
+	}
+	return strings.Join(hunk, "\n")
+}
+
+// Line is a line of a patch that review comments can be attached to: an
+// added line or an unchanged context line
+type Line struct {
+	// Old is the line number in the old file, 0 for added lines
+	Old int
+	// New is the line number in the new file
+	New int
+	// Hunk is the index of the hunk the line is in
+	Hunk int
+}
+
+// Lines returns the lines of a file's hunks that exist in the new file,
+// keyed by their new line number. Removed lines cannot be commented on by new
+// line number and are left out.
+func Lines(hunks []types.Hunk) map[int]Line {
+	lines := make(map[int]Line)
+	for i, hunk := range hunks {
+		for _, line := range hunk.Lines {
+			switch line.Kind {
+			case types.LineAdded:
+				lines[line.NewLine] = Line{New: line.NewLine, Hunk: i}
+			case types.LineContext:
+				lines[line.NewLine] = Line{Old: line.OldLine, New: line.NewLine, Hunk: i}
+			}
+		}
+	}
+	return lines
+}
+
+// Touched reports whether changes removed or replaced any of the lines from
+// start to end of the old version of a file. Every line of a removed file is
+// touched.
+func Touched(changes []types.FileChange, file string, start, end int) bool {
+	for _, change := range changes {
+		if change.OldPath != file {
+			continue
+		}
+		if change.Status == types.FileRemoved {
+			return true
+		}
+		for _, hunk := range change.Hunks {
+			for _, line := range hunk.Lines {
+				if line.Kind == types.LineRemoved && line.OldLine >= start && line.OldLine <= end {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// InOneHunk reports whether the new-file lines from start to end are all
+// shown in the same hunk of a patch, as returned by Lines
+func InOneHunk(lines map[int]Line, start, end int) bool {
+	first, ok := lines[start]
+	if !ok || end < start {
+		return false
+	}
+	for n := start + 1; n <= end; n++ {
+		if line, ok := lines[n]; !ok || line.Hunk != first.Hunk {
+			return false
+		}
+	}
+	return true
+}
+
+// hunkOldRange parses the old-file range of a hunk header such as
+// "@@ -1,4 +1,6 @@"
+func hunkOldRange(header string) (int, int) {
+	for _, field := range strings.Fields(header) {
+		if !strings.HasPrefix(field, "-") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(field, "-"), ",", 2)
+		start, _ := strconv.Atoi(parts[0])
+		count := 1
+		if len(parts) == 2 {
+			count, _ = strconv.Atoi(parts[1])
+		}
+		return start, count
+	}
+	return 0, 0
+}
+
+// hunkRange parses the new-file range of a hunk header such as
+// "@@ -1,4 +1,6 @@"
+func hunkRange(header string) (int, int) {
+	for _, field := range strings.Fields(header) {
+		if !strings.HasPrefix(field, "+") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(field, "+"), ",", 2)
+		start, _ := strconv.Atoi(parts[0])
+		count := 1
+		if len(parts) == 2 {
+			count, _ = strconv.Atoi(parts[1])
+		}
+		return start, count
+	}
+	return 0, 0
+}
+
+// maxDiffCells caps the size of the table Unified builds; larger changes are
+// shown as the old lines replaced by the new ones
+const maxDiffCells = 4_000_000
+
+// edit is one line of an edit script: ' ' keeps, '-' deletes and '+' inserts
+type edit struct {
+	kind byte
+	text string
+}
+
+// Unified returns the hunks of a unified diff between two versions of a
+// file, with the given number of context lines and without file headers
+func Unified(oldText, newText string, context int) string {
+	edits := diffLines(splitLines(oldText), splitLines(newText))
+
+	// Line numbers before each edit
+	oldLine := make([]int, len(edits)+1)
+	newLine := make([]int, len(edits)+1)
+	oldLine[0], newLine[0] = 1, 1
+	var changed []int
+	for i, e := range edits {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if e.kind != '+' {
+			oldLine[i+1]++
+		}
+		if e.kind != '-' {
+			newLine[i+1]++
+		}
+		if e.kind != ' ' {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	emit := func(first, last int) {
+		from := first - context
+		if from < 0 {
+			from = 0
+		}
+		to := last + context
+		if to > len(edits)-1 {
+			to = len(edits) - 1
+		}
+
+		oldStart, newStart := oldLine[from], newLine[from]
+		oldCount := oldLine[to+1] - oldStart
+		newCount := newLine[to+1] - newStart
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, e := range edits[from : to+1] {
+			b.WriteByte(e.kind)
+			b.WriteString(e.text)
+			b.WriteByte('\n')
+		}
+	}
+
+	first, last := changed[0], changed[0]
+	for _, i := range changed[1:] {
+		if i-last > 2*context {
+			emit(first, last)
+			first = i
+		}
+		last = i
+	}
+	emit(first, last)
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// diffLines computes an edit script between two lists of lines from their
+// longest common subsequence
+func diffLines(a, b []string) []edit {
+	// Common prefix and suffix need no table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	for _, line := range a[:prefix] {
+		edits = append(edits, edit{' ', line})
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, line := range midA {
+			edits = append(edits, edit{'-', line})
+		}
+		for _, line := range midB {
+			edits = append(edits, edit{'+', line})
+		}
+	} else {
+		edits = append(edits, lcsEdits(midA, midB)...)
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, edit{' ', line})
+	}
+	return edits
+}
+
+func lcsEdits(a, b []string) []edit {
+	n, m := len(a), len(b)
+	// lengths[i*(m+1)+j] is the LCS length of a[i:] and b[j:]
+	lengths := make([]int32, (n+1)*(m+1))
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i*(m+1)+j] = lengths[(i+1)*(m+1)+j+1] + 1
+			case lengths[(i+1)*(m+1)+j] >= lengths[i*(m+1)+j+1]:
+				lengths[i*(m+1)+j] = lengths[(i+1)*(m+1)+j]
+			default:
+				lengths[i*(m+1)+j] = lengths[i*(m+1)+j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case lengths[(i+1)*(m+1)+j] >= lengths[i*(m+1)+j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		edits = append(edits, edit{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		edits = append(edits, edit{'+', b[j]})
+	}
+	return edits
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
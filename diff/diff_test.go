@@ -0,0 +1,404 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+
+	"pr-agent-reviewer/types"
+)
+
+func TestParseHunks(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  []types.Hunk
+	}{
+		{
+			name:  "empty patch",
+			patch: "",
+			want:  nil,
+		},
+		{
+			name:  "single hunk",
+			patch: "@@ -1,3 +1,3 @@ func main() {\n a\n-b\n+c\n d",
+			want: []types.Hunk{{
+				OldStart: 1, OldLines: 3, NewStart: 1, NewLines: 3, Section: "func main() {",
+				Lines: []types.DiffLine{
+					{Kind: types.LineContext, Text: "a", OldLine: 1, NewLine: 1},
+					{Kind: types.LineRemoved, Text: "b", OldLine: 2},
+					{Kind: types.LineAdded, Text: "c", NewLine: 2},
+					{Kind: types.LineContext, Text: "d", OldLine: 3, NewLine: 3},
+				},
+			}},
+		},
+		{
+			name:  "no newline at end of file",
+			patch: "@@ -1 +1 @@\n-old\n\\ No newline at end of file\n+new\n\\ No newline at end of file",
+			want: []types.Hunk{{
+				OldStart: 1, OldLines: 1, NewStart: 1, NewLines: 1,
+				Lines: []types.DiffLine{
+					{Kind: types.LineRemoved, Text: "old", OldLine: 1},
+					{Kind: types.LineAdded, Text: "new", NewLine: 1},
+				},
+			}},
+		},
+		{
+			name:  "multiple hunks",
+			patch: "@@ -1,2 +1,3 @@\n a\n+b\n c\n@@ -10,2 +11,1 @@ type T struct\n x\n-y",
+			want: []types.Hunk{
+				{
+					OldStart: 1, OldLines: 2, NewStart: 1, NewLines: 3,
+					Lines: []types.DiffLine{
+						{Kind: types.LineContext, Text: "a", OldLine: 1, NewLine: 1},
+						{Kind: types.LineAdded, Text: "b", NewLine: 2},
+						{Kind: types.LineContext, Text: "c", OldLine: 2, NewLine: 3},
+					},
+				},
+				{
+					OldStart: 10, OldLines: 2, NewStart: 11, NewLines: 1, Section: "type T struct",
+					Lines: []types.DiffLine{
+						{Kind: types.LineContext, Text: "x", OldLine: 10, NewLine: 11},
+						{Kind: types.LineRemoved, Text: "y", OldLine: 11},
+					},
+				},
+			},
+		},
+		{
+			name:  "added file",
+			patch: "@@ -0,0 +1,2 @@\n+a\n+b",
+			want: []types.Hunk{{
+				OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 2,
+				Lines: []types.DiffLine{
+					{Kind: types.LineAdded, Text: "a", NewLine: 1},
+					{Kind: types.LineAdded, Text: "b", NewLine: 2},
+				},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseHunks(tt.patch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseHunks() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []FilePatch
+	}{
+		{
+			name: "modified file",
+			text: "diff --git a/main.go b/main.go\n" +
+				"index 83db48f..bf269f4 100644\n" +
+				"--- a/main.go\n" +
+				"+++ b/main.go\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n+c",
+			want: []FilePatch{
+				{Path: "main.go", OldPath: "main.go", Status: types.FileModified, Patch: "@@ -1,2 +1,2 @@\n a\n-b\n+c"},
+			},
+		},
+		{
+			name: "added and deleted files",
+			text: "diff --git a/new.go b/new.go\n" +
+				"new file mode 100644\n" +
+				"index 0000000..e69de29\n" +
+				"--- /dev/null\n" +
+				"+++ b/new.go\n" +
+				"@@ -0,0 +1 @@\n+a\n" +
+				"diff --git a/old.go b/old.go\n" +
+				"deleted file mode 100644\n" +
+				"index e69de29..0000000\n" +
+				"--- a/old.go\n" +
+				"+++ /dev/null\n" +
+				"@@ -1 +0,0 @@\n-a",
+			want: []FilePatch{
+				{Path: "new.go", OldPath: "new.go", Status: types.FileAdded, Patch: "@@ -0,0 +1 @@\n+a"},
+				{Path: "old.go", OldPath: "old.go", Status: types.FileRemoved, Patch: "@@ -1 +0,0 @@\n-a"},
+			},
+		},
+		{
+			name: "rename without changes",
+			text: "diff --git a/a.go b/b.go\n" +
+				"similarity index 100%\n" +
+				"rename from a.go\n" +
+				"rename to b.go",
+			want: []FilePatch{
+				{Path: "b.go", OldPath: "a.go", Status: types.FileRenamed},
+			},
+		},
+		{
+			name: "rename with changes",
+			text: "diff --git a/a.go b/b.go\n" +
+				"similarity index 90%\n" +
+				"rename from a.go\n" +
+				"rename to b.go\n" +
+				"--- a/a.go\n" +
+				"+++ b/b.go\n" +
+				"@@ -1 +1 @@\n-x\n+y",
+			want: []FilePatch{
+				{Path: "b.go", OldPath: "a.go", Status: types.FileRenamed, Patch: "@@ -1 +1 @@\n-x\n+y"},
+			},
+		},
+		{
+			name: "binary file",
+			text: "diff --git a/logo.png b/logo.png\n" +
+				"index 1111111..2222222 100644\n" +
+				"Binary files a/logo.png and b/logo.png differ",
+			want: []FilePatch{
+				{Path: "logo.png", OldPath: "logo.png", Status: types.FileModified, Binary: true},
+			},
+		},
+		{
+			name: "no diff",
+			text: "",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitFiles(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitFiles() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		context int
+		want    string
+	}{
+		{
+			name:    "identical",
+			oldText: "a\nb\n",
+			newText: "a\nb\n",
+			context: 3,
+			want:    "",
+		},
+		{
+			name:    "changed line",
+			oldText: "a\nb\nc\n",
+			newText: "a\nx\nc\n",
+			context: 1,
+			want:    "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c",
+		},
+		{
+			name:    "added file",
+			oldText: "",
+			newText: "a\nb\n",
+			context: 3,
+			want:    "@@ -0,0 +1,2 @@\n+a\n+b",
+		},
+		{
+			name:    "deleted file",
+			oldText: "a\nb\n",
+			newText: "",
+			context: 3,
+			want:    "@@ -1,2 +0,0 @@\n-a\n-b",
+		},
+		{
+			name:    "distant changes",
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			newText: "x\n2\n3\n4\n5\n6\n7\ny\n",
+			context: 1,
+			want:    "@@ -1,2 +1,2 @@\n-1\n+x\n 2\n@@ -7,2 +7,2 @@\n 7\n-8\n+y",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified(tt.oldText, tt.newText, tt.context); got != tt.want {
+				t.Errorf("Unified() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHunkAt(t *testing.T) {
+	patch := "@@ -1,2 +1,3 @@\n a\n+b\n c\n@@ -10,2 +11,2 @@\n x\n-y\n+z"
+
+	tests := []struct {
+		name string
+		line int
+		want string
+	}{
+		{name: "first hunk", line: 2, want: "@@ -1,2 +1,3 @@\n a\n+b\n c"},
+		{name: "second hunk", line: 12, want: "@@ -10,2 +11,2 @@\n x\n-y\n+z"},
+		{name: "outside every hunk", line: 7, want: patch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HunkAt(patch, tt.line); got != tt.want {
+				t.Errorf("HunkAt(%d) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  map[int]Line
+	}{
+		{
+			name:  "empty patch",
+			patch: "",
+			want:  map[int]Line{},
+		},
+		{
+			name:  "single hunk",
+			patch: "@@ -1,3 +1,3 @@ func main() {\n a\n-b\n+c\n d",
+			want:  map[int]Line{1: {Old: 1, New: 1}, 2: {New: 2}, 3: {Old: 3, New: 3}},
+		},
+		{
+			name:  "no newline at end of file",
+			patch: "@@ -1 +1 @@\n-old\n\\ No newline at end of file\n+new\n\\ No newline at end of file",
+			want:  map[int]Line{1: {New: 1}},
+		},
+		{
+			name:  "multiple hunks",
+			patch: "@@ -1,2 +1,3 @@\n a\n+b\n c\n@@ -10,2 +11,1 @@ type T struct\n x\n-y",
+			want:  map[int]Line{1: {Old: 1, New: 1}, 2: {New: 2}, 3: {Old: 2, New: 3}, 11: {Old: 10, New: 11, Hunk: 1}},
+		},
+		{
+			name:  "added file",
+			patch: "@@ -0,0 +1,2 @@\n+a\n+b",
+			want:  map[int]Line{1: {New: 1}, 2: {New: 2}},
+		},
+		{
+			name:  "deleted file",
+			patch: "@@ -1,2 +0,0 @@\n-a\n-b",
+			want:  map[int]Line{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Lines(ParseHunks(tt.patch)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lines() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInOneHunk(t *testing.T) {
+	lines := Lines(ParseHunks("@@ -1,2 +1,3 @@\n a\n+b\n c\n@@ -10,2 +11,2 @@\n x\n-y\n+z"))
+
+	tests := []struct {
+		name       string
+		start, end int
+		want       bool
+	}{
+		{name: "single line", start: 2, end: 2, want: true},
+		{name: "range in one hunk", start: 1, end: 3, want: true},
+		{name: "range across hunks", start: 3, end: 11, want: false},
+		{name: "line outside the patch", start: 5, end: 5, want: false},
+		{name: "range ending outside the patch", start: 11, end: 13, want: false},
+		{name: "reversed range", start: 3, end: 1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InOneHunk(lines, tt.start, tt.end); got != tt.want {
+				t.Errorf("InOneHunk(%d, %d) = %t, want %t", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFileChange(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		patch         string
+		wantAdditions int
+		wantDeletions int
+		wantBinary    bool
+		wantLanguage  string
+	}{
+		{
+			name:          "text patch",
+			path:          "cmd/main.go",
+			patch:         "@@ -1,3 +1,4 @@\n a\n-b\n+c\n+d\n e",
+			wantAdditions: 2,
+			wantDeletions: 1,
+			wantLanguage:  "Go",
+		},
+		{
+			name:         "binary patch",
+			path:         "logo.png",
+			patch:        "Binary files a/logo.png and b/logo.png differ",
+			wantBinary:   true,
+			wantLanguage: Language("logo.png"),
+		},
+		{
+			name:         "no patch",
+			path:         "README.md",
+			patch:        "",
+			wantLanguage: Language("README.md"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewFileChange(tt.path, tt.patch)
+			if got.Path != tt.path || got.OldPath != tt.path || got.Status != types.FileModified {
+				t.Errorf("NewFileChange() paths and status = %q, %q, %q", got.Path, got.OldPath, got.Status)
+			}
+			if got.Additions != tt.wantAdditions || got.Deletions != tt.wantDeletions {
+				t.Errorf("NewFileChange() counted +%d -%d, want +%d -%d", got.Additions, got.Deletions, tt.wantAdditions, tt.wantDeletions)
+			}
+			if got.Binary != tt.wantBinary {
+				t.Errorf("NewFileChange().Binary = %t, want %t", got.Binary, tt.wantBinary)
+			}
+			if got.Language != tt.wantLanguage {
+				t.Errorf("NewFileChange().Language = %q, want %q", got.Language, tt.wantLanguage)
+			}
+		})
+	}
+}
+
+func TestTouched(t *testing.T) {
+	renamed := NewFileChange("new.go", "@@ -4 +4 @@\n-y\n+z")
+	renamed.OldPath, renamed.Status = "old.go", types.FileRenamed
+
+	changes := []types.FileChange{
+		NewFileChange("main.go", "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c"),
+		{Path: "gone.go", OldPath: "gone.go", Status: types.FileRemoved},
+		renamed,
+	}
+
+	tests := []struct {
+		name       string
+		file       string
+		start, end int
+		want       bool
+	}{
+		{name: "replaced line", file: "main.go", start: 2, end: 2, want: true},
+		{name: "context line", file: "main.go", start: 1, end: 1, want: false},
+		{name: "range over a replaced line", file: "main.go", start: 1, end: 3, want: true},
+		{name: "removed file", file: "gone.go", start: 5, end: 5, want: true},
+		{name: "renamed file by its old path", file: "old.go", start: 4, end: 4, want: true},
+		{name: "renamed file by its new path", file: "new.go", start: 4, end: 4, want: false},
+		{name: "untouched file", file: "other.go", start: 2, end: 2, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Touched(changes, tt.file, tt.start, tt.end); got != tt.want {
+				t.Errorf("Touched(%s, %d, %d) = %t, want %t", tt.file, tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
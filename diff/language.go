@@ -0,0 +1,65 @@
+package diff
+
+import (
+	"path"
+	"strings"
+)
+
+// languages maps file extensions to programming languages
+var languages = map[string]string{
+	".c":     "C",
+	".h":     "C",
+	".cc":    "C++",
+	".cpp":   "C++",
+	".hpp":   "C++",
+	".cs":    "C#",
+	".css":   "CSS",
+	".dart":  "Dart",
+	".ex":    "Elixir",
+	".exs":   "Elixir",
+	".go":    "Go",
+	".html":  "HTML",
+	".java":  "Java",
+	".js":    "JavaScript",
+	".jsx":   "JavaScript",
+	".mjs":   "JavaScript",
+	".json":  "JSON",
+	".kt":    "Kotlin",
+	".lua":   "Lua",
+	".md":    "Markdown",
+	".php":   "PHP",
+	".py":    "Python",
+	".rb":    "Ruby",
+	".rs":    "Rust",
+	".scala": "Scala",
+	".scss":  "SCSS",
+	".sh":    "Shell",
+	".sql":   "SQL",
+	".swift": "Swift",
+	".tf":    "Terraform",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript",
+	".vue":   "Vue",
+	".xml":   "XML",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+}
+
+// languageFiles maps well-known file names without a telling extension to
+// their language
+var languageFiles = map[string]string{
+	"Dockerfile":  "Dockerfile",
+	"Makefile":    "Makefile",
+	"go.mod":      "Go Module",
+	"Jenkinsfile": "Groovy",
+}
+
+// Language guesses the programming language of a file from its name, or
+// returns an empty string when it is not known
+func Language(file string) string {
+	name := path.Base(file)
+	if language, ok := languageFiles[name]; ok {
+		return language
+	}
+	return languages[strings.ToLower(path.Ext(name))]
+}
@@ -0,0 +1,563 @@
+package gitea
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	diffutil "pr-agent-reviewer/diff"
+	"pr-agent-reviewer/httpclient"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/types"
+)
+
+// pageSize is the number of items requested per page of a list endpoint
+const pageSize = 50
+
+// Client represents a Gitea/Forgejo client
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+
+	// login is the bot's own username, looked up once
+	loginMu sync.Mutex
+	login   string
+}
+
+// NewClient creates a new Gitea/Forgejo client
+func NewClient() *Client {
+	token := os.Getenv("GITEA_TOKEN")
+	if token == "" {
+		logger.LogError("GITEA_TOKEN environment variable is not set", nil)
+		return nil
+	}
+
+	baseURL := os.Getenv("GITEA_URL")
+	if baseURL == "" {
+		logger.LogError("GITEA_URL environment variable is not set", nil)
+		return nil
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/api/v1",
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// reviewComment is a pull request review comment as returned by the API
+type reviewComment struct {
+	ID       int64  `json:"id"`
+	Body     string `json:"body"`
+	Path     string `json:"path"`
+	Position int    `json:"position"`
+	DiffHunk string `json:"diff_hunk"`
+	User     struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// changedFile is a file changed by a PR as returned by the API
+type changedFile struct {
+	Filename         string `json:"filename"`
+	PreviousFilename string `json:"previous_filename"`
+	Status           string `json:"status"`
+	Additions        int    `json:"additions"`
+	Deletions        int    `json:"deletions"`
+}
+
+// GetChanges implements the vcs.Provider interface
+func (c *Client) GetChanges(ctx context.Context, repo string, prNumber int) ([]types.FileChange, error) {
+	logger.LogInfo("Getting changes for PR #%d in %s", prNumber, repo)
+
+	var files []changedFile
+	err := c.list(ctx, fmt.Sprintf("%s/pulls/%d/files", repoPath(repo), prNumber), func(data []byte) (int, error) {
+		var page []changedFile
+		if err := json.Unmarshal(data, &page); err != nil {
+			return 0, err
+		}
+		files = append(files, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		logger.LogError("Failed to get PR files", err)
+		return nil, fmt.Errorf("failed to get PR files: %w", err)
+	}
+
+	text, err := c.getText(ctx, fmt.Sprintf("%s/pulls/%d.diff", repoPath(repo), prNumber))
+	if err != nil {
+		logger.LogError("Failed to get PR diff", err)
+		return nil, fmt.Errorf("failed to get PR diff: %w", err)
+	}
+
+	patches := make(map[string]diffutil.FilePatch)
+	for _, file := range diffutil.SplitFiles(text) {
+		patches[file.Path] = file
+	}
+
+	changes := make([]types.FileChange, 0, len(files))
+	for _, file := range files {
+		change := diffutil.NewFileChange(file.Filename, "")
+		if patch, ok := patches[file.Filename]; ok {
+			change = patch.Change()
+		}
+		change.Additions, change.Deletions = file.Additions, file.Deletions
+		switch file.Status {
+		case "added":
+			change.Status = types.FileAdded
+		case "deleted":
+			change.Status = types.FileRemoved
+		case "renamed":
+			change.Status = types.FileRenamed
+			change.OldPath = file.PreviousFilename
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// GetChangesBetween implements the vcs.Provider interface. The Gitea API has
+// no diff between two commits, so the files touched by the commits in between
+// are diffed from their contents at both commits.
+func (c *Client) GetChangesBetween(ctx context.Context, repo string, prNumber int, fromSHA, toSHA string) ([]types.FileChange, error) {
+	logger.LogInfo("Getting changes for PR #%d in %s between %s and %s", prNumber, repo, fromSHA, toSHA)
+
+	var comparison struct {
+		Commits []struct {
+			SHA string `json:"sha"`
+		} `json:"commits"`
+	}
+	path := fmt.Sprintf("%s/compare/%s", repoPath(repo), url.PathEscape(fromSHA+"..."+toSHA))
+	if err := c.do(ctx, http.MethodGet, path, nil, &comparison); err != nil {
+		logger.LogError("Failed to compare commits", err)
+		return nil, fmt.Errorf("failed to compare commits: %w", err)
+	}
+
+	// Follow each file through the commits, from its path at fromSHA to its
+	// path at toSHA
+	var files []*touchedFile
+	byPath := make(map[string]*touchedFile)
+	for _, commit := range comparison.Commits {
+		text, err := c.getText(ctx, fmt.Sprintf("%s/git/commits/%s.diff", repoPath(repo), url.PathEscape(commit.SHA)))
+		if err != nil {
+			logger.LogError("Failed to get commit diff", err)
+			return nil, fmt.Errorf("failed to get commit diff: %w", err)
+		}
+		for _, patch := range diffutil.SplitFiles(text) {
+			source := patch.Path
+			if patch.Status == types.FileRenamed {
+				source = patch.OldPath
+			}
+			file, seen := byPath[source]
+			if !seen {
+				file = &touchedFile{oldPath: source, existed: patch.Status != types.FileAdded}
+				files = append(files, file)
+			}
+			delete(byPath, source)
+			byPath[patch.Path] = file
+			file.path = patch.Path
+			file.removed = patch.Status == types.FileRemoved
+			file.binary = file.binary || patch.Binary
+		}
+	}
+
+	changes := make([]types.FileChange, 0, len(files))
+	for _, file := range files {
+		if !file.existed && file.removed {
+			continue
+		}
+
+		var oldText, newText string
+		var err error
+		if file.existed && !file.binary {
+			if oldText, err = c.fileAt(ctx, repo, file.oldPath, fromSHA); err != nil {
+				return nil, err
+			}
+		}
+		if !file.removed && !file.binary {
+			if newText, err = c.fileAt(ctx, repo, file.path, toSHA); err != nil {
+				return nil, err
+			}
+		}
+		if file.existed && !file.removed && !file.binary && file.oldPath == file.path && oldText == newText {
+			// Changed and changed back
+			continue
+		}
+
+		// Files with NUL bytes are binary and are not diffed
+		var patch string
+		binary := file.binary || strings.ContainsRune(oldText, 0) || strings.ContainsRune(newText, 0)
+		if !binary {
+			patch = diffutil.Unified(oldText, newText, 3)
+		}
+		change := diffutil.NewFileChange(file.path, patch)
+		change.Binary = binary
+		switch {
+		case !file.existed:
+			change.Status = types.FileAdded
+		case file.removed:
+			change.Status = types.FileRemoved
+		case file.oldPath != file.path:
+			change.Status = types.FileRenamed
+			change.OldPath = file.oldPath
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// touchedFile is a file changed by the commits between two commits
+type touchedFile struct {
+	oldPath string
+	path    string
+	existed bool
+	removed bool
+	binary  bool
+}
+
+// fileAt returns the content of a file at a commit
+func (c *Client) fileAt(ctx context.Context, repo, path, sha string) (string, error) {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+	text, err := c.getText(ctx, fmt.Sprintf("%s/raw/%s?ref=%s", repoPath(repo), strings.Join(segments, "/"), url.QueryEscape(sha)))
+	if err != nil {
+		logger.LogError("Failed to get file content", err)
+		return "", fmt.Errorf("failed to get file content: %w", err)
+	}
+	return text, nil
+}
+
+// GetHeadSHA implements the vcs.Provider interface
+func (c *Client) GetHeadSHA(ctx context.Context, repo string, prNumber int) (string, error) {
+	var pr struct {
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/pulls/%d", repoPath(repo), prNumber), nil, &pr); err != nil {
+		logger.LogError("Failed to get PR details", err)
+		return "", fmt.Errorf("failed to get PR details: %w", err)
+	}
+	return pr.Head.SHA, nil
+}
+
+// CreateReview implements the vcs.Provider interface
+func (c *Client) CreateReview(ctx context.Context, repo string, prNumber int, review *types.Review) error {
+	logger.LogInfo("Creating review for PR #%d in %s", prNumber, repo)
+
+	var comments []map[string]interface{}
+	for _, finding := range review.Findings {
+		comments = append(comments, map[string]interface{}{
+			"path": finding.File, "body": finding.CommentBody(""), "new_position": finding.Line,
+		})
+	}
+
+	event := reviewEvent(review.Verdict)
+	err := c.createReview(ctx, repo, prNumber, review.Body, event, comments)
+
+	// A comment outside the diff fails the whole review, so post the
+	// findings in the body instead
+	var statusErr *httpclient.StatusError
+	if err != nil && len(comments) > 0 && errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusUnprocessableEntity) {
+		logger.LogInfo("Inline comments rejected for PR #%d, posting findings in the review body: %v", prNumber, err)
+		err = c.createReview(ctx, repo, prNumber, review.Body+types.FormatFindings("Findings", review.Findings), event, nil)
+	}
+	if err != nil {
+		logger.LogError("Failed to create PR review", err)
+		return fmt.Errorf("failed to create PR review: %w", err)
+	}
+	return nil
+}
+
+// CreateInlineComment posts a comment on a line of the new version of a file
+func (c *Client) CreateInlineComment(ctx context.Context, repo string, prNumber int, path string, line int, body string) error {
+	logger.LogInfo("Creating inline comment on %s:%d for PR #%d in %s", path, line, prNumber, repo)
+
+	comments := []map[string]interface{}{
+		{"path": path, "body": body, "new_position": line},
+	}
+	if err := c.createReview(ctx, repo, prNumber, "", "COMMENT", comments); err != nil {
+		logger.LogError("Failed to create inline comment", err)
+		return fmt.Errorf("failed to create inline comment: %w", err)
+	}
+	return nil
+}
+
+// reviewEvent returns the review event that submits a verdict
+func reviewEvent(verdict types.Verdict) string {
+	switch verdict {
+	case types.VerdictApprove:
+		return "APPROVED"
+	case types.VerdictRequestChanges:
+		return "REQUEST_CHANGES"
+	default:
+		return "COMMENT"
+	}
+}
+
+func (c *Client) createReview(ctx context.Context, repo string, prNumber int, body, event string, comments []map[string]interface{}) error {
+	payload := map[string]interface{}{
+		"body":  body,
+		"event": event,
+	}
+	if len(comments) > 0 {
+		payload["comments"] = comments
+	}
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("%s/pulls/%d/reviews", repoPath(repo), prNumber), payload, nil)
+}
+
+// PostComment implements the vcs.Provider interface. The thread ID is the ID
+// of a review comment. Gitea has no replies in the API, so the reply is
+// posted on the same line, where it joins the same conversation.
+func (c *Client) PostComment(ctx context.Context, repo string, prNumber int, threadID, body string) error {
+	if threadID == "" {
+		logger.LogInfo("Posting comment on PR #%d in %s", prNumber, repo)
+		path := fmt.Sprintf("%s/issues/%d/comments", repoPath(repo), prNumber)
+		if err := c.do(ctx, http.MethodPost, path, map[string]string{"body": body}, nil); err != nil {
+			logger.LogError("Failed to post PR comment", err)
+			return fmt.Errorf("failed to post PR comment: %w", err)
+		}
+		return nil
+	}
+
+	comments, err := c.reviewComments(ctx, repo, prNumber)
+	if err != nil {
+		return err
+	}
+	root, ok := findComment(comments, threadID)
+	if !ok {
+		return fmt.Errorf("review comment %s not found on PR #%d", threadID, prNumber)
+	}
+
+	logger.LogInfo("Replying to review comment %s on PR #%d in %s", threadID, prNumber, repo)
+	return c.CreateInlineComment(ctx, repo, prNumber, root.Path, root.Position, body)
+}
+
+// GetThread implements the vcs.Provider interface. The thread ID is the ID of
+// a review comment; the thread holds every review comment on the same line.
+func (c *Client) GetThread(ctx context.Context, repo string, prNumber int, threadID string) (*types.ReviewThread, error) {
+	logger.LogInfo("Getting review thread %s on PR #%d in %s", threadID, prNumber, repo)
+
+	login, err := c.botLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := c.reviewComments(ctx, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := findComment(comments, threadID)
+	if !ok {
+		return nil, fmt.Errorf("review comment %s not found on PR #%d", threadID, prNumber)
+	}
+
+	thread := &types.ReviewThread{ID: threadID, File: root.Path, DiffHunk: root.DiffHunk}
+	for _, comment := range comments {
+		if comment.Path != root.Path || comment.Position != root.Position {
+			continue
+		}
+		thread.Comments = append(thread.Comments, types.ThreadComment{
+			Author: comment.User.Login,
+			Body:   comment.Body,
+			Own:    comment.User.Login == login,
+		})
+	}
+	return thread, nil
+}
+
+// reviewComments returns the comments of every review on a PR, oldest first
+func (c *Client) reviewComments(ctx context.Context, repo string, prNumber int) ([]reviewComment, error) {
+	var reviewIDs []int64
+	err := c.list(ctx, fmt.Sprintf("%s/pulls/%d/reviews", repoPath(repo), prNumber), func(data []byte) (int, error) {
+		var page []struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return 0, err
+		}
+		for _, review := range page {
+			reviewIDs = append(reviewIDs, review.ID)
+		}
+		return len(page), nil
+	})
+	if err != nil {
+		logger.LogError("Failed to list PR reviews", err)
+		return nil, fmt.Errorf("failed to list PR reviews: %w", err)
+	}
+
+	var comments []reviewComment
+	for _, reviewID := range reviewIDs {
+		var page []reviewComment
+		path := fmt.Sprintf("%s/pulls/%d/reviews/%d/comments", repoPath(repo), prNumber, reviewID)
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			logger.LogError("Failed to list review comments", err)
+			return nil, fmt.Errorf("failed to list review comments: %w", err)
+		}
+		comments = append(comments, page...)
+	}
+
+	sort.Slice(comments, func(i, j int) bool { return comments[i].ID < comments[j].ID })
+	return comments, nil
+}
+
+func findComment(comments []reviewComment, id string) (reviewComment, bool) {
+	for _, comment := range comments {
+		if strconv.FormatInt(comment.ID, 10) == id {
+			return comment, true
+		}
+	}
+	return reviewComment{}, false
+}
+
+// IsCollaborator implements the vcs.Provider interface
+func (c *Client) IsCollaborator(ctx context.Context, repo, username string) (bool, error) {
+	var permission struct {
+		Permission string `json:"permission"`
+	}
+	path := fmt.Sprintf("%s/collaborators/%s/permission", repoPath(repo), url.PathEscape(username))
+	if err := c.do(ctx, http.MethodGet, path, nil, &permission); err != nil {
+		logger.LogError("Failed to check collaborator", err)
+		return false, fmt.Errorf("failed to check collaborator: %w", err)
+	}
+
+	switch permission.Permission {
+	case "write", "admin", "owner":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// botLogin returns the username the client posts as: GITEA_BOT_USERNAME when
+// set, otherwise the owner of the token
+func (c *Client) botLogin(ctx context.Context) (string, error) {
+	if login := os.Getenv("GITEA_BOT_USERNAME"); login != "" {
+		return login, nil
+	}
+
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	if c.login != "" {
+		return c.login, nil
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/user", nil, &user); err != nil {
+		logger.LogError("Failed to get authenticated user", err)
+		return "", fmt.Errorf("failed to get authenticated user: %w", err)
+	}
+	c.login = user.Login
+	return c.login, nil
+}
+
+// list walks the pages of a list endpoint. fn decodes a page and returns the
+// number of items on it; a short page ends the walk.
+func (c *Client) list(ctx context.Context, path string, fn func([]byte) (int, error)) error {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	for page := 1; ; page++ {
+		data, err := c.getText(ctx, fmt.Sprintf("%s%spage=%d&limit=%d", path, sep, page, pageSize))
+		if err != nil {
+			return err
+		}
+		count, err := fn([]byte(data))
+		if err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		if count < pageSize {
+			return nil
+		}
+	}
+}
+
+// do sends a JSON request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// getText fetches a raw resource such as a diff
+func (c *Client) getText(ctx context.Context, path string) (string, error) {
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return string(data), nil
+}
+
+// send sends an authenticated request to a path relative to the API URL
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "token "+c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &httpclient.StatusError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	return resp, nil
+}
+
+// repoPath returns the API path of an "owner/repo" repository
+func repoPath(repo string) string {
+	owner, name, _ := strings.Cut(repo, "/")
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name)
+}
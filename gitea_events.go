@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"pr-agent-reviewer/command"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/policy"
+	"pr-agent-reviewer/queue"
+	"pr-agent-reviewer/types"
+	"pr-agent-reviewer/webhook"
+)
+
+// giteaEvents routes Gitea/Forgejo webhooks by their X-Gitea-Event header
+var giteaEvents = webhook.NewDispatcher("Gitea")
+
+func init() {
+	giteaEvents.Register("pull_request", webhook.Typed(handleGiteaPullRequest))
+	giteaEvents.Register("issue_comment", webhook.Typed(handleGiteaIssueComment))
+}
+
+func handleGiteaWebhook(w http.ResponseWriter, r *http.Request, body []byte) {
+	giteaEvents.Dispatch(w, r, r.Header.Get("X-Gitea-Event"), body)
+}
+
+func handleGiteaPullRequest(w http.ResponseWriter, r *http.Request, event *types.GiteaPullRequestEvent) {
+	pr := event.PullRequest
+	logger.LogWebhook("pull_request", event.Action, event)
+
+	// Review opened PRs and new pushes, and re-evaluate the policy when the
+	// title or labels change. Gitea marks drafts with a title prefix.
+	switch event.Action {
+	case "opened", "reopened", "synchronized", "edited", "label_updated", "label_cleared":
+	default:
+		logger.LogInfo("Skipping PR #%d: action is %s", pr.Number, event.Action)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	input := policy.PullRequest{
+		Draft:        pr.Draft,
+		Title:        pr.Title,
+		TargetBranch: pr.Base.Ref,
+		Author:       pr.User.Login,
+		DiffLines:    -1,
+	}
+	for _, label := range pr.Labels {
+		input.Labels = append(input.Labels, label.Name)
+	}
+	if ok, reason := reviewPolicy.Evaluate(input); !ok {
+		logger.LogInfo("Skipping PR #%d: %s", pr.Number, reason)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Skip reviews of a head SHA that is already running or done
+	if !dedupStore.BeginReview(requestHost(r), event.Repository.FullName, pr.Number, pr.Head.SHA) {
+		logger.LogInfo("Skipping PR #%d: review for %s already running or done", pr.Number, pr.Head.SHA)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Queue PR for review
+	job := &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: pr.Number,
+		Repo:     event.Repository.FullName,
+		HeadSHA:  pr.Head.SHA,
+		Title:    pr.Title,
+		URL:      pr.URL,
+	}
+	if err := reviewQueue.Enqueue(job); err != nil {
+		logger.LogError("Failed to queue PR review", err)
+		dedupStore.AbandonReview(job.Provider, job.Repo, job.PRNumber, job.HeadSHA)
+		dedupStore.ForgetDelivery(deliveryID(r))
+		http.Error(w, "Failed to queue review", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGiteaIssueComment(w http.ResponseWriter, r *http.Request, event *types.GiteaIssueCommentEvent) {
+	logger.LogWebhook("issue_comment", event.Action, event)
+
+	// Only new comments on PRs from someone other than the bot can hold commands
+	botName := os.Getenv("GITEA_BOT_USERNAME")
+	if event.Action != "created" || !event.IsPull || (botName != "" && event.Comment.User.Login == botName) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	cmd, ok := command.Parse(event.Comment.Body)
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	enqueueCommand(w, r, cmd, &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: event.Issue.Number,
+		Repo:     event.Repository.FullName,
+		Title:    event.Issue.Title,
+		URL:      event.Issue.URL,
+		Author:   event.Comment.User.Login,
+	})
+}
@@ -0,0 +1,248 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"pr-agent-reviewer/logger"
+
+	gh "github.com/google/go-github/v57/github"
+)
+
+// tokenRefreshMargin is how long before expiry an installation token is
+// replaced
+const tokenRefreshMargin = 5 * time.Minute
+
+type installationKey struct{}
+
+// WithInstallation returns a context that makes GitHub App clients act as
+// the given installation, as taken from a webhook's installation.id
+func WithInstallation(ctx context.Context, installationID int64) context.Context {
+	if installationID == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, installationKey{}, installationID)
+}
+
+func installationFrom(ctx context.Context) int64 {
+	id, _ := ctx.Value(installationKey{}).(int64)
+	return id
+}
+
+// installationToken is a cached installation access token. Its lock is held
+// while the token is created, so that concurrent requests of one
+// installation create it only once without blocking other installations.
+type installationToken struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// appAuth authenticates as a GitHub App: it signs JWTs with the app's private
+// key and exchanges them for installation access tokens, which are cached
+// until shortly before they expire
+type appAuth struct {
+	appID  int64
+	key    *rsa.PrivateKey
+	client *gh.Client
+	base   http.RoundTripper
+
+	mu            sync.Mutex
+	tokens        map[int64]*installationToken
+	installations map[string]int64
+	slug          string
+}
+
+// newAppAuth creates the GitHub App authentication for a client. Requests
+// go out through base.
+func newAppAuth(cfg Config, base http.RoundTripper) (*appAuth, error) {
+	appID, err := strconv.ParseInt(cfg.AppID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App ID: %v", err)
+	}
+
+	keyPEM := []byte(cfg.AppPrivateKey)
+	if len(keyPEM) == 0 && cfg.AppPrivateKeyPath != "" {
+		if keyPEM, err = os.ReadFile(cfg.AppPrivateKeyPath); err != nil {
+			return nil, fmt.Errorf("failed to read GitHub App private key: %v", err)
+		}
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &appAuth{
+		appID:         appID,
+		key:           key,
+		base:          base,
+		tokens:        make(map[int64]*installationToken),
+		installations: make(map[string]int64),
+	}
+	a.client, err = newAPIClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		jwt, err := a.jwt()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		return a.base.RoundTrip(req)
+	})}, cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// transport returns a round tripper that authenticates requests with the
+// token of the installation in the request context
+func (a *appAuth) transport() http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		installationID := installationFrom(req.Context())
+		if installationID == 0 {
+			return nil, fmt.Errorf("no GitHub App installation for request to %s", req.URL.Path)
+		}
+
+		token, err := a.token(req.Context(), installationID)
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "token "+token)
+		return a.base.RoundTrip(req)
+	})
+}
+
+// jwt returns a JSON Web Token identifying the app, signed with RS256. The
+// issue time is backdated to allow for clock drift.
+func (a *appAuth) jwt() (string, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.appID, 10),
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GitHub App JWT: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// token returns an access token for an installation, creating a new one when
+// the cached token is missing or about to expire
+func (a *appAuth) token(ctx context.Context, installationID int64) (string, error) {
+	a.mu.Lock()
+	cached, ok := a.tokens[installationID]
+	if !ok {
+		cached = &installationToken{}
+		a.tokens[installationID] = cached
+	}
+	a.mu.Unlock()
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	if cached.token != "" && time.Until(cached.expiresAt) > tokenRefreshMargin {
+		return cached.token, nil
+	}
+
+	logger.LogInfo("Creating access token for GitHub App installation %d", installationID)
+	token, _, err := a.client.Apps.CreateInstallationToken(ctx, installationID, nil)
+	if err != nil {
+		logger.LogError("Failed to create installation token", err)
+		return "", fmt.Errorf("failed to create installation token: %w", err)
+	}
+
+	cached.token = token.GetToken()
+	cached.expiresAt = token.GetExpiresAt().Time
+	return cached.token, nil
+}
+
+// installation returns the installation of the app on a repository, for
+// jobs that do not carry one
+func (a *appAuth) installation(ctx context.Context, owner, repo string) (int64, error) {
+	key := owner + "/" + repo
+	a.mu.Lock()
+	id, ok := a.installations[key]
+	a.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	installation, _, err := a.client.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		logger.LogError("Failed to find GitHub App installation", err)
+		return 0, fmt.Errorf("failed to find GitHub App installation for %s: %w", key, err)
+	}
+
+	a.mu.Lock()
+	a.installations[key] = installation.GetID()
+	a.mu.Unlock()
+	return installation.GetID(), nil
+}
+
+// botLogin returns the login of the app's bot user, e.g. "my-app[bot]"
+func (a *appAuth) botLogin(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	slug := a.slug
+	a.mu.Unlock()
+	if slug != "" {
+		return slug + "[bot]", nil
+	}
+
+	app, _, err := a.client.Apps.Get(ctx, "")
+	if err != nil {
+		logger.LogError("Failed to get GitHub App", err)
+		return "", fmt.Errorf("failed to get GitHub App: %w", err)
+	}
+
+	a.mu.Lock()
+	a.slug = app.GetSlug()
+	a.mu.Unlock()
+	return app.GetSlug() + "[bot]", nil
+}
+
+// parsePrivateKey parses a PEM encoded RSA key in PKCS#1 form, as GitHub
+// issues them, or PKCS#8 form
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid GitHub App private key: no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid GitHub App private key: not an RSA key")
+	}
+	return key, nil
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
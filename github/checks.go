@@ -0,0 +1,196 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/types"
+
+	gh "github.com/google/go-github/v57/github"
+)
+
+// checkName is the name of the check run branch protection can require
+const checkName = "AI Review"
+
+// Check run modes
+const (
+	// checksOff posts reviews only
+	checksOff = "off"
+	// checksOn publishes a check run in addition to the review
+	checksOn = "on"
+	// checksOnly publishes a check run instead of the pull request review;
+	// the summary comment is still updated
+	checksOnly = "only"
+)
+
+// GitHub limits the annotations per request and the length of a check run's
+// summary
+const (
+	maxAnnotationsPerRequest = 50
+	maxCheckSummary          = 65535
+)
+
+// parseCheckRuns returns the check run mode configured by GITHUB_CHECK_RUNS
+func parseCheckRuns(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", checksOff, "false":
+		return checksOff, nil
+	case checksOn, "true":
+		return checksOn, nil
+	case checksOnly:
+		return checksOnly, nil
+	default:
+		return "", fmt.Errorf("invalid check run mode %q, expected off, on or only", value)
+	}
+}
+
+// StartCheck implements the vcs.CheckReporter interface
+func (c *Client) StartCheck(ctx context.Context, repo string, prNumber int, headSHA string) (string, error) {
+	if c.checkRuns == checksOff {
+		return "", nil
+	}
+
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid repository format: %s", repo)
+	}
+	owner, repoName := parts[0], parts[1]
+
+	ctx, err := c.authorize(ctx, owner, repoName)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if headSHA == "" {
+		var err error
+		if headSHA, err = c.GetHeadSHA(ctx, repo, prNumber); err != nil {
+			return "", err
+		}
+	}
+
+	logger.LogInfo("Starting check run for PR #%d in %s at %s", prNumber, repo, headSHA)
+	run, _, err := c.client.Checks.CreateCheckRun(ctx, owner, repoName, gh.CreateCheckRunOptions{
+		Name:       checkName,
+		HeadSHA:    headSHA,
+		ExternalID: gh.String(strconv.Itoa(prNumber)),
+		Status:     gh.String("in_progress"),
+		StartedAt:  &gh.Timestamp{Time: time.Now()},
+		Output: &gh.CheckRunOutput{
+			Title:   gh.String("Review in progress"),
+			Summary: gh.String("The AI review of this commit is running."),
+		},
+	})
+	if err != nil {
+		logger.LogError("Failed to create check run", err)
+		return "", fmt.Errorf("failed to create check run: %w", err)
+	}
+	return strconv.FormatInt(run.GetID(), 10), nil
+}
+
+// CompleteCheck implements the vcs.CheckReporter interface. GitHub accepts 50
+// annotations per request, so further findings are added in follow-up
+// updates.
+func (c *Client) CompleteCheck(ctx context.Context, repo string, prNumber int, checkID string, result *types.CheckResult) error {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid repository format: %s", repo)
+	}
+	owner, repoName := parts[0], parts[1]
+
+	runID, err := strconv.ParseInt(checkID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid check run ID: %s", checkID)
+	}
+
+	ctx, err = c.authorize(ctx, owner, repoName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	summary := result.Summary
+	if len(summary) > maxCheckSummary {
+		summary = strings.ToValidUTF8(summary[:maxCheckSummary-len(truncatedNote)], "") + truncatedNote
+	}
+
+	annotations := checkAnnotations(result.Findings)
+	first := annotations
+	if len(first) > maxAnnotationsPerRequest {
+		first = first[:maxAnnotationsPerRequest]
+	}
+	output := &gh.CheckRunOutput{
+		Title:       gh.String(result.Title),
+		Summary:     gh.String(summary),
+		Annotations: first,
+	}
+
+	logger.LogInfo("Completing check run %d for PR #%d in %s with conclusion %s", runID, prNumber, repo, result.Conclusion)
+	_, _, err = c.client.Checks.UpdateCheckRun(ctx, owner, repoName, runID, gh.UpdateCheckRunOptions{
+		Name:        checkName,
+		Status:      gh.String("completed"),
+		Conclusion:  gh.String(string(result.Conclusion)),
+		CompletedAt: &gh.Timestamp{Time: time.Now()},
+		Output:      output,
+	})
+	if err != nil {
+		logger.LogError("Failed to complete check run", err)
+		return fmt.Errorf("failed to complete check run: %w", err)
+	}
+
+	for start := maxAnnotationsPerRequest; start < len(annotations); start += maxAnnotationsPerRequest {
+		end := min(start+maxAnnotationsPerRequest, len(annotations))
+		output.Annotations = annotations[start:end]
+		_, _, err = c.client.Checks.UpdateCheckRun(ctx, owner, repoName, runID, gh.UpdateCheckRunOptions{
+			Name:   checkName,
+			Output: output,
+		})
+		if err != nil {
+			logger.LogError("Failed to add check run annotations", err)
+			return fmt.Errorf("failed to add check run annotations: %w", err)
+		}
+	}
+	return nil
+}
+
+// truncatedNote ends a check run summary that was cut to GitHub's limit
+const truncatedNote = "\n\n…the review was truncated; see the summary comment for the full text."
+
+// checkAnnotations turns findings into check run annotations, with the
+// annotation level following the severity
+func checkAnnotations(findings []types.Finding) []*gh.CheckRunAnnotation {
+	annotations := make([]*gh.CheckRunAnnotation, 0, len(findings))
+	for _, finding := range findings {
+		level := "warning"
+		switch finding.Severity {
+		case types.SeverityCritical, types.SeverityMajor:
+			level = "failure"
+		case types.SeverityNit:
+			level = "notice"
+		}
+
+		annotation := &gh.CheckRunAnnotation{
+			Path:            gh.String(finding.File),
+			StartLine:       gh.Int(finding.FirstLine()),
+			EndLine:         gh.Int(finding.Line),
+			AnnotationLevel: gh.String(level),
+			Message:         gh.String(finding.Body),
+		}
+		if finding.Severity != "" {
+			annotation.Title = gh.String(strings.ToUpper(finding.Severity[:1]) + finding.Severity[1:])
+		}
+		if finding.Suggestion != "" {
+			annotation.RawDetails = gh.String(finding.Suggestion)
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations
+}
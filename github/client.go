@@ -2,12 +2,20 @@ package github
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	diffutil "pr-agent-reviewer/diff"
+	"pr-agent-reviewer/httpclient"
 	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/sticky"
+	"pr-agent-reviewer/types"
 
 	gh "github.com/google/go-github/v57/github"
 )
@@ -15,87 +23,416 @@ import (
 // Client represents a GitHub client
 type Client struct {
 	client *gh.Client
+	// app is set when authenticating as a GitHub App rather than with a
+	// personal access token
+	app *appAuth
+	// checkRuns is the check run mode: off, on or only
+	checkRuns string
+
+	// login is the bot's own username, looked up once
+	loginMu sync.Mutex
+	login   string
+}
+
+// Config configures a client for github.com or a GitHub Enterprise Server
+// instance
+type Config struct {
+	// URL is the instance URL, e.g. https://github.example.com. It is empty
+	// for github.com.
+	URL   string
+	Token string
+	// AppID and the private key, inline or as a file path, authenticate as
+	// a GitHub App instead of with Token
+	AppID             string
+	AppPrivateKey     string
+	AppPrivateKeyPath string
+	// CAFile is a PEM bundle of additional CAs to trust
+	CAFile string
+	// CheckRuns publishes reviews as check runs: off (the default), on to
+	// publish them in addition to the pull request review, or only to
+	// publish them instead. Check runs need GitHub App authentication.
+	CheckRuns string
 }
 
-// NewClient creates a new GitHub client
+// NewClient creates a new GitHub client. It authenticates as a GitHub App
+// when GITHUB_APP_ID is set, and with GITHUB_TOKEN otherwise.
 func NewClient() *Client {
-	token := os.Getenv("GITHUB_TOKEN")
-	if token == "" {
+	cfg := Config{
+		URL:               os.Getenv("GITHUB_URL"),
+		Token:             os.Getenv("GITHUB_TOKEN"),
+		AppID:             os.Getenv("GITHUB_APP_ID"),
+		AppPrivateKey:     os.Getenv("GITHUB_APP_PRIVATE_KEY"),
+		AppPrivateKeyPath: os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH"),
+		CAFile:            os.Getenv("GITHUB_CA_FILE"),
+		CheckRuns:         os.Getenv("GITHUB_CHECK_RUNS"),
+	}
+	if cfg.AppID == "" && cfg.Token == "" {
 		logger.LogError("GITHUB_TOKEN environment variable is not set", nil)
 		return nil
 	}
+	return NewClientWithConfig(cfg)
+}
+
+// NewClientWithConfig creates a new GitHub client for the given host
+func NewClientWithConfig(cfg Config) *Client {
+	transport, err := httpclient.Transport(cfg.CAFile)
+	if err != nil {
+		logger.LogError("Failed to configure GitHub HTTP client", err)
+		return nil
+	}
+
+	checkRuns, err := parseCheckRuns(cfg.CheckRuns)
+	if err != nil {
+		logger.LogError("Failed to configure GitHub check runs", err)
+		return nil
+	}
+	// Only GitHub Apps may create check runs
+	if checkRuns != checksOff && cfg.AppID == "" {
+		logger.LogError("Check runs need GitHub App authentication, posting reviews only", nil)
+		checkRuns = checksOff
+	}
+
+	if cfg.AppID != "" {
+		app, err := newAppAuth(cfg, transport)
+		if err != nil {
+			logger.LogError("Failed to configure GitHub App authentication", err)
+			return nil
+		}
+		client, err := newAPIClient(&http.Client{Transport: app.transport()}, cfg.URL)
+		if err != nil {
+			logger.LogError("Failed to create GitHub client", err)
+			return nil
+		}
+		return &Client{client: client, app: app, checkRuns: checkRuns}
+	}
+
+	if cfg.Token == "" {
+		logger.LogError("GitHub token is not set", nil)
+		return nil
+	}
+
+	client, err := newAPIClient(&http.Client{Transport: transport}, cfg.URL)
+	if err != nil {
+		logger.LogError("Failed to create GitHub client", err)
+		return nil
+	}
+	return &Client{client: client.WithAuthToken(cfg.Token), checkRuns: checkRuns}
+}
+
+// newAPIClient creates a go-github client for github.com, or for the REST
+// API of a GitHub Enterprise Server instance when url is set
+func newAPIClient(httpClient *http.Client, url string) (*gh.Client, error) {
+	client := gh.NewClient(httpClient)
+	if url == "" {
+		return client, nil
+	}
+	client, err := client.WithEnterpriseURLs(url, url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub Enterprise URL %s: %w", url, err)
+	}
+	return client, nil
+}
+
+// authorize returns a context carrying the GitHub App installation to act as
+// on a repository. The installation from the webhook is used when present,
+// otherwise it is looked up. With a personal access token it does nothing.
+func (c *Client) authorize(ctx context.Context, owner, repo string) (context.Context, error) {
+	if c.app == nil || installationFrom(ctx) != 0 {
+		return ctx, nil
+	}
 
-	client := gh.NewClient(nil).WithAuthToken(token)
-	return &Client{client: client}
+	installationID, err := c.app.installation(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return WithInstallation(ctx, installationID), nil
 }
 
 // GetChanges implements the vcs.Provider interface
-func (c *Client) GetChanges(repo string, prNumber int) ([]string, error) {
+func (c *Client) GetChanges(ctx context.Context, repo string, prNumber int) ([]types.FileChange, error) {
 	parts := strings.Split(repo, "/")
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("invalid repository format: %s", repo)
 	}
 	owner, repoName := parts[0], parts[1]
 
+	ctx, err := c.authorize(ctx, owner, repoName)
+	if err != nil {
+		return nil, err
+	}
+
 	logger.LogInfo("Getting changes for PR #%d in %s", prNumber, repo)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
-	files, _, err := c.client.PullRequests.ListFiles(
-		ctx,
-		owner,
-		repoName,
-		prNumber,
-		nil,
-	)
+	var files []*gh.CommitFile
+	opts := &gh.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := c.client.PullRequests.ListFiles(ctx, owner, repoName, prNumber, opts)
+		if err != nil {
+			logger.LogError("Failed to get PR changes", err)
+			return nil, fmt.Errorf("failed to get PR changes: %w", err)
+		}
+		files = append(files, page...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	// GitHub lists at most 3000 files and leaves out the patches of large
+	// files, so those come from the diff of the whole PR instead
+	var total int
+	if len(files) >= maxListedFiles {
+		pr, _, err := c.client.PullRequests.Get(ctx, owner, repoName, prNumber)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get PR details: %w", err)
+		}
+		total = pr.GetChangedFiles()
+	}
+	if total > len(files) || missingPatches(files) {
+		raw, _, err := c.client.PullRequests.GetRaw(ctx, owner, repoName, prNumber, gh.RawOptions{Type: gh.Diff})
+		if err != nil {
+			logger.LogError(fmt.Sprintf("Failed to get the diff of PR #%d", prNumber), err)
+		} else {
+			files = fillPatches(files, raw)
+		}
+	}
+
+	// A review of part of the files would pass for a review of all of them
+	if total > len(files) {
+		return nil, fmt.Errorf("PR #%d changes %d files, more than the %d GitHub lists, and its diff is too large to retrieve", prNumber, total, len(files))
+	}
+	return fileChanges(files), nil
+}
+
+// GetChangesBetween implements the vcs.Provider interface
+func (c *Client) GetChangesBetween(ctx context.Context, repo string, prNumber int, fromSHA, toSHA string) ([]types.FileChange, error) {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid repository format: %s", repo)
+	}
+	owner, repoName := parts[0], parts[1]
+
+	ctx, err := c.authorize(ctx, owner, repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.LogInfo("Getting changes for PR #%d in %s between %s and %s", prNumber, repo, fromSHA, toSHA)
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	comparison, _, err := c.client.Repositories.CompareCommits(ctx, owner, repoName, fromSHA, toSHA, nil)
 	if err != nil {
-		logger.LogError("Failed to get PR changes", err)
-		return nil, fmt.Errorf("failed to get PR changes: %v", err)
+		logger.LogError("Failed to compare commits", err)
+		return nil, fmt.Errorf("failed to compare commits: %w", err)
+	}
+
+	// A comparison lists at most 300 files, so larger ones, and files
+	// without a patch, come from the diff between the commits
+	files := comparison.Files
+	truncated := len(files) >= maxComparedFiles
+	if truncated || missingPatches(files) {
+		raw, _, err := c.client.Repositories.CompareCommitsRaw(ctx, owner, repoName, fromSHA, toSHA, gh.RawOptions{Type: gh.Diff})
+		if err != nil {
+			logger.LogError(fmt.Sprintf("Failed to get the diff between %s and %s", fromSHA, toSHA), err)
+		} else {
+			files = fillPatches(files, raw)
+			truncated = false
+		}
+	}
+
+	if truncated {
+		return nil, fmt.Errorf("the changes between %s and %s span more than the %d files GitHub compares, and their diff is too large to retrieve", fromSHA, toSHA, maxComparedFiles)
 	}
+	return fileChanges(files), nil
+}
+
+// GitHub's limits on the files listed for a pull request and a comparison
+const (
+	maxListedFiles   = 3000
+	maxComparedFiles = 300
+)
 
-	var changes []string
+// missingPatches reports whether GitHub left out the patch of a changed text
+// file, which it does for large diffs
+func missingPatches(files []*gh.CommitFile) bool {
 	for _, file := range files {
-		changes = append(changes, fmt.Sprintf("File: %s\nPatch:\n%s", file.GetFilename(), file.GetPatch()))
+		if file.GetPatch() == "" && file.GetChanges() > 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	return changes, nil
+// fillPatches fills in missing patches from a multi-file diff and adds the
+// files of the diff that are not listed yet
+func fillPatches(files []*gh.CommitFile, raw string) []*gh.CommitFile {
+	listed := make(map[string]*gh.CommitFile, len(files))
+	for _, file := range files {
+		listed[file.GetFilename()] = file
+	}
+
+	for _, patch := range diffutil.SplitFiles(raw) {
+		file, ok := listed[patch.Path]
+		if !ok {
+			change := patch.Change()
+			file = &gh.CommitFile{
+				Filename:         gh.String(change.Path),
+				PreviousFilename: gh.String(change.OldPath),
+				Status:           gh.String(string(change.Status)),
+				Additions:        gh.Int(change.Additions),
+				Deletions:        gh.Int(change.Deletions),
+				Changes:          gh.Int(change.Additions + change.Deletions),
+			}
+			files = append(files, file)
+			listed[patch.Path] = file
+		}
+		if file.GetPatch() == "" && patch.Patch != "" {
+			file.Patch = gh.String(patch.Patch)
+		}
+	}
+	return files
+}
+
+// GetHeadSHA implements the vcs.Provider interface
+func (c *Client) GetHeadSHA(ctx context.Context, repo string, prNumber int) (string, error) {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid repository format: %s", repo)
+	}
+	owner, repoName := parts[0], parts[1]
+
+	ctx, err := c.authorize(ctx, owner, repoName)
+	if err != nil {
+		return "", err
+	}
+
+	pr, _, err := c.client.PullRequests.Get(ctx, owner, repoName, prNumber)
+	if err != nil {
+		logger.LogError("Failed to get PR details", err)
+		return "", fmt.Errorf("failed to get PR details: %w", err)
+	}
+	return pr.GetHead().GetSHA(), nil
+}
+
+// fileChanges converts the changed files GitHub lists. Files without a patch
+// are binary when no lines changed, and too large to diff otherwise.
+func fileChanges(files []*gh.CommitFile) []types.FileChange {
+	changes := make([]types.FileChange, 0, len(files))
+	for _, file := range files {
+		change := diffutil.NewFileChange(file.GetFilename(), file.GetPatch())
+		change.Additions = file.GetAdditions()
+		change.Deletions = file.GetDeletions()
+		if file.GetPreviousFilename() != "" {
+			change.OldPath = file.GetPreviousFilename()
+		}
+
+		switch file.GetStatus() {
+		case "added", "copied":
+			change.Status = types.FileAdded
+		case "removed":
+			change.Status = types.FileRemoved
+		case "renamed":
+			change.Status = types.FileRenamed
+		}
+
+		if file.GetPatch() == "" {
+			if file.GetChanges() > 0 {
+				change.TooLarge = true
+			} else if change.Status != types.FileRenamed {
+				change.Binary = true
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
 }
 
 // CreateReview implements the vcs.Provider interface
-func (c *Client) CreateReview(repo string, prNumber int, review string) error {
+func (c *Client) CreateReview(ctx context.Context, repo string, prNumber int, review *types.Review) error {
 	parts := strings.Split(repo, "/")
 	if len(parts) != 2 {
 		return fmt.Errorf("invalid repository format: %s", repo)
 	}
 	owner, repoName := parts[0], parts[1]
 
+	ctx, err := c.authorize(ctx, owner, repoName)
+	if err != nil {
+		return err
+	}
+
 	logger.LogInfo("Creating review for PR #%d in %s", prNumber, repo)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	// Get PR details to check the author
 	pr, _, err := c.client.PullRequests.Get(ctx, owner, repoName, prNumber)
 	if err != nil {
-		return fmt.Errorf("failed to get PR details: %v", err)
+		return fmt.Errorf("failed to get PR details: %w", err)
 	}
 
-	// Determine the review event based on the PR author
-	event := "REQUEST_CHANGES"
+	// Determine the review event from the verdict. GitHub does not allow
+	// approving or requesting changes on your own PR.
+	event := reviewEvent(review.Verdict)
 	botUsername := os.Getenv("GITHUB_BOT_USERNAME")
+	if botUsername == "" && c.app != nil {
+		botUsername, _ = c.app.botLogin(ctx)
+	}
 	if botUsername != "" && pr.GetUser().GetLogin() == botUsername {
 		event = "COMMENT"
 		logger.LogInfo("Using COMMENT event for self-authored PR (author: %s, bot: %s)", pr.GetUser().GetLogin(), botUsername)
 	} else {
-		logger.LogInfo("Using REQUEST_CHANGES event (author: %s, bot: %s)", pr.GetUser().GetLogin(), botUsername)
+		logger.LogInfo("Using %s event for %s verdict (author: %s, bot: %s)", event, review.Verdict, pr.GetUser().GetLogin(), botUsername)
+	}
+
+	login, err := c.botLogin(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Earlier findings on lines changed since are superseded by this review.
+	// Failing to mark them does not stop the new review from being posted.
+	if err := c.markFindingsOutdated(ctx, owner, repoName, prNumber, login, review.HeadSHA); err != nil {
+		logger.LogError("Failed to mark earlier findings outdated", err)
+	}
+
+	summary, err := c.upsertSummary(ctx, owner, repoName, prNumber, login, review, review.Body)
+	if err != nil {
+		return err
+	}
+
+	// The review itself only carries the inline findings and the verdict;
+	// the text lives in the summary comment. With check runs only, both
+	// are reported by the check run instead, unless the check run could not
+	// be started.
+	if (c.checkRuns == checksOnly && review.CheckID != "") || (len(review.Findings) == 0 && event == "COMMENT") {
+		return nil
 	}
 
-	// Create a review with a comment indicating requested changes
 	reviewRequest := &gh.PullRequestReviewRequest{
-		Body:  gh.String(review),
+		Body:  gh.String(fmt.Sprintf("See the [review summary](%s).", summary.GetHTMLURL())),
 		Event: gh.String(event),
 	}
+	for _, finding := range review.Findings {
+		comment := &gh.DraftReviewComment{
+			Path: gh.String(finding.File),
+			Line: gh.Int(finding.Line),
+			Side: gh.String("RIGHT"),
+			Body: gh.String(sticky.Finding(finding.CommentBody("suggestion"), finding.Blocking)),
+		}
+		// Multi-line comments let a suggestion replace the whole range
+		if first := finding.FirstLine(); first != finding.Line {
+			comment.StartLine = gh.Int(first)
+			comment.StartSide = gh.String("RIGHT")
+		}
+		reviewRequest.Comments = append(reviewRequest.Comments, comment)
+	}
 
 	_, _, err = c.client.PullRequests.CreateReview(
 		ctx,
@@ -104,24 +441,215 @@ func (c *Client) CreateReview(repo string, prNumber int, review string) error {
 		prNumber,
 		reviewRequest,
 	)
+
+	// GitHub rejects the whole review when a comment is not on a line of the
+	// diff, so list the findings in the summary comment instead
+	var errResp *gh.ErrorResponse
+	if err != nil && len(review.Findings) > 0 && errors.As(err, &errResp) && errResp.Response.StatusCode == http.StatusUnprocessableEntity {
+		logger.LogInfo("Inline comments rejected for PR #%d, posting findings in the summary comment: %v", prNumber, err)
+		if _, err := c.upsertSummary(ctx, owner, repoName, prNumber, login, review, review.Body+types.FormatFindings("Findings", review.Findings)); err != nil {
+			return err
+		}
+		if event == "COMMENT" {
+			return nil
+		}
+		reviewRequest.Comments = nil
+		_, _, err = c.client.PullRequests.CreateReview(ctx, owner, repoName, prNumber, reviewRequest)
+	}
 	if err != nil {
 		logger.LogError("Failed to create PR review", err)
-		return fmt.Errorf("failed to create PR review: %v", err)
+		return fmt.Errorf("failed to create PR review: %w", err)
 	}
 
 	return nil
 }
 
+// reviewEvent returns the review event that submits a verdict
+func reviewEvent(verdict types.Verdict) string {
+	switch verdict {
+	case types.VerdictApprove:
+		return "APPROVE"
+	case types.VerdictRequestChanges:
+		return "REQUEST_CHANGES"
+	default:
+		return "COMMENT"
+	}
+}
+
+// PostComment implements the vcs.Provider interface. The thread ID is the ID
+// of the review comment to reply to.
+func (c *Client) PostComment(ctx context.Context, repo string, prNumber int, threadID, body string) error {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid repository format: %s", repo)
+	}
+	owner, repoName := parts[0], parts[1]
+
+	ctx, err := c.authorize(ctx, owner, repoName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if threadID == "" {
+		logger.LogInfo("Posting comment on PR #%d in %s", prNumber, repo)
+		_, _, err := c.client.Issues.CreateComment(ctx, owner, repoName, prNumber, &gh.IssueComment{Body: gh.String(body)})
+		if err != nil {
+			logger.LogError("Failed to post PR comment", err)
+			return fmt.Errorf("failed to post PR comment: %w", err)
+		}
+		return nil
+	}
+
+	commentID, err := strconv.ParseInt(threadID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid review comment ID: %s", threadID)
+	}
+
+	logger.LogInfo("Replying to review comment %d on PR #%d in %s", commentID, prNumber, repo)
+	_, _, err = c.client.PullRequests.CreateCommentInReplyTo(ctx, owner, repoName, prNumber, body, commentID)
+	if err != nil {
+		logger.LogError("Failed to reply to review comment", err)
+		return fmt.Errorf("failed to reply to review comment: %w", err)
+	}
+	return nil
+}
+
+// IsCollaborator implements the vcs.Provider interface
+func (c *Client) IsCollaborator(ctx context.Context, repo, username string) (bool, error) {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 {
+		return false, fmt.Errorf("invalid repository format: %s", repo)
+	}
+	owner, repoName := parts[0], parts[1]
+
+	ctx, err := c.authorize(ctx, owner, repoName)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	ok, _, err := c.client.Repositories.IsCollaborator(ctx, owner, repoName, username)
+	if err != nil {
+		logger.LogError("Failed to check collaborator", err)
+		return false, fmt.Errorf("failed to check collaborator: %w", err)
+	}
+	return ok, nil
+}
+
+// GetThread implements the vcs.Provider interface. The thread ID is the ID of
+// the review comment that started the thread.
+func (c *Client) GetThread(ctx context.Context, repo string, prNumber int, threadID string) (*types.ReviewThread, error) {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid repository format: %s", repo)
+	}
+	owner, repoName := parts[0], parts[1]
+
+	ctx, err := c.authorize(ctx, owner, repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	rootID, err := strconv.ParseInt(threadID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid review comment ID: %s", threadID)
+	}
+
+	logger.LogInfo("Getting review thread %d on PR #%d in %s", rootID, prNumber, repo)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	login, err := c.botLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	thread := &types.ReviewThread{ID: threadID}
+	opts := &gh.PullRequestListCommentsOptions{
+		Sort:        "created",
+		Direction:   "asc",
+		ListOptions: gh.ListOptions{PerPage: 100},
+	}
+	for {
+		comments, resp, err := c.client.PullRequests.ListComments(ctx, owner, repoName, prNumber, opts)
+		if err != nil {
+			logger.LogError("Failed to list review comments", err)
+			return nil, fmt.Errorf("failed to list review comments: %w", err)
+		}
+
+		for _, comment := range comments {
+			if comment.GetID() != rootID && comment.GetInReplyTo() != rootID {
+				continue
+			}
+			if comment.GetID() == rootID {
+				thread.File = comment.GetPath()
+				thread.DiffHunk = comment.GetDiffHunk()
+			}
+			thread.Comments = append(thread.Comments, types.ThreadComment{
+				Author: comment.GetUser().GetLogin(),
+				Body:   comment.GetBody(),
+				Own:    comment.GetUser().GetLogin() == login,
+			})
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if len(thread.Comments) == 0 {
+		return nil, fmt.Errorf("review comment %d not found on PR #%d", rootID, prNumber)
+	}
+	return thread, nil
+}
+
+// botLogin returns the username the client posts as: GITHUB_BOT_USERNAME when
+// set, otherwise the app's bot user or the owner of the token
+func (c *Client) botLogin(ctx context.Context) (string, error) {
+	if login := os.Getenv("GITHUB_BOT_USERNAME"); login != "" {
+		return login, nil
+	}
+	if c.app != nil {
+		return c.app.botLogin(ctx)
+	}
+
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	if c.login != "" {
+		return c.login, nil
+	}
+
+	user, _, err := c.client.Users.Get(ctx, "")
+	if err != nil {
+		logger.LogError("Failed to get authenticated user", err)
+		return "", fmt.Errorf("failed to get authenticated user: %w", err)
+	}
+	c.login = user.GetLogin()
+	return c.login, nil
+}
+
 func (c *Client) GetPRDetails(owner, repo string, prNumber int) (*gh.PullRequest, error) {
 	logger.LogInfo("Fetching details for PR #%d in %s/%s", prNumber, owner, repo)
 	
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, err := c.authorize(context.Background(), owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	pr, _, err := c.client.PullRequests.Get(ctx, owner, repo, prNumber)
 	if err != nil {
 		logger.LogError("Failed to get PR details", err)
-		return nil, fmt.Errorf("failed to get PR details: %v", err)
+		return nil, fmt.Errorf("failed to get PR details: %w", err)
 	}
 
 	logger.LogInfo("Successfully retrieved details for PR #%d", prNumber)
@@ -0,0 +1,144 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	diffutil "pr-agent-reviewer/diff"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/sticky"
+	"pr-agent-reviewer/types"
+
+	gh "github.com/google/go-github/v57/github"
+)
+
+// upsertSummary adds a review round to the bot's summary comment on a PR,
+// creating the comment if the bot has not posted one yet
+func (c *Client) upsertSummary(ctx context.Context, owner, repo string, prNumber int, login string, review *types.Review, text string) (*gh.IssueComment, error) {
+	existing, err := c.findSummary(ctx, owner, repo, prNumber, login)
+	if err != nil {
+		return nil, err
+	}
+
+	heading := sticky.Heading(review.HeadSHA, time.Now())
+	if existing == nil {
+		logger.LogInfo("Creating summary comment on PR #%d in %s/%s", prNumber, owner, repo)
+		comment, _, err := c.client.Issues.CreateComment(ctx, owner, repo, prNumber, &gh.IssueComment{
+			Body: gh.String(sticky.Compose("", review.ID, heading, text)),
+		})
+		if err != nil {
+			logger.LogError("Failed to create summary comment", err)
+			return nil, fmt.Errorf("failed to create summary comment: %w", err)
+		}
+		return comment, nil
+	}
+
+	logger.LogInfo("Updating summary comment %d on PR #%d in %s/%s", existing.GetID(), prNumber, owner, repo)
+	comment, _, err := c.client.Issues.EditComment(ctx, owner, repo, existing.GetID(), &gh.IssueComment{
+		Body: gh.String(sticky.Compose(existing.GetBody(), review.ID, heading, text)),
+	})
+	if err != nil {
+		logger.LogError("Failed to update summary comment", err)
+		return nil, fmt.Errorf("failed to update summary comment: %w", err)
+	}
+	return comment, nil
+}
+
+// findSummary returns the bot's summary comment on a PR, or nil if there is
+// none
+func (c *Client) findSummary(ctx context.Context, owner, repo string, prNumber int, login string) (*gh.IssueComment, error) {
+	opts := &gh.IssueListCommentsOptions{ListOptions: gh.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := c.client.Issues.ListComments(ctx, owner, repo, prNumber, opts)
+		if err != nil {
+			logger.LogError("Failed to list PR comments", err)
+			return nil, fmt.Errorf("failed to list PR comments: %w", err)
+		}
+
+		for _, comment := range comments {
+			if comment.GetUser().GetLogin() == login && sticky.IsSummary(comment.GetBody()) {
+				return comment, nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// markFindingsOutdated collapses the bot's inline findings from earlier
+// reviews of a PR whose lines have changed since the commit they were made
+// on. Findings on unchanged lines still apply and are left as they are.
+func (c *Client) markFindingsOutdated(ctx context.Context, owner, repo string, prNumber int, login, headSHA string) error {
+	opts := &gh.PullRequestListCommentsOptions{ListOptions: gh.ListOptions{PerPage: 100}}
+	var findings []*gh.PullRequestComment
+	for {
+		comments, resp, err := c.client.PullRequests.ListComments(ctx, owner, repo, prNumber, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list review comments: %w", err)
+		}
+
+		for _, comment := range comments {
+			if comment.GetUser().GetLogin() == login && sticky.IsFinding(comment.GetBody()) {
+				findings = append(findings, comment)
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+
+	if headSHA == "" {
+		var err error
+		if headSHA, err = c.GetHeadSHA(ctx, owner+"/"+repo, prNumber); err != nil {
+			return err
+		}
+	}
+
+	changesSince := make(map[string][]types.FileChange)
+	var outdated []*gh.PullRequestComment
+	for _, comment := range findings {
+		commit := comment.GetOriginalCommitID()
+		if commit == headSHA {
+			continue
+		}
+		changes, ok := changesSince[commit]
+		if !ok {
+			var err error
+			if changes, err = c.GetChangesBetween(ctx, owner+"/"+repo, prNumber, commit, headSHA); err != nil {
+				return err
+			}
+			changesSince[commit] = changes
+		}
+
+		end := comment.GetOriginalLine()
+		start := comment.GetOriginalStartLine()
+		if start == 0 {
+			start = end
+		}
+		if diffutil.Touched(changes, comment.GetPath(), start, end) {
+			outdated = append(outdated, comment)
+		}
+	}
+
+	for _, comment := range outdated {
+		_, _, err := c.client.PullRequests.EditComment(ctx, owner, repo, comment.GetID(), &gh.PullRequestComment{
+			Body: gh.String(sticky.Outdated(comment.GetBody())),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update review comment %d: %w", comment.GetID(), err)
+		}
+	}
+	if len(outdated) > 0 {
+		logger.LogInfo("Marked %d earlier findings outdated on PR #%d in %s/%s", len(outdated), prNumber, owner, repo)
+	}
+	return nil
+}
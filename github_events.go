@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"pr-agent-reviewer/command"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/policy"
+	"pr-agent-reviewer/queue"
+	"pr-agent-reviewer/types"
+	"pr-agent-reviewer/webhook"
+)
+
+// githubEvents routes GitHub webhooks by their X-GitHub-Event header
+var githubEvents = webhook.NewDispatcher("GitHub")
+
+func init() {
+	githubEvents.Register("ping", webhook.Typed(handleGitHubPing))
+	githubEvents.Register("pull_request", webhook.Typed(handleGitHubPullRequest))
+	githubEvents.Register("pull_request_review_comment", webhook.Typed(handleGitHubReviewComment))
+	githubEvents.Register("issue_comment", webhook.Typed(handleGitHubIssueComment))
+	githubEvents.Register("check_suite", webhook.Typed(handleGitHubCheckSuite))
+}
+
+func handleGitHubWebhook(w http.ResponseWriter, r *http.Request, body []byte) {
+	githubEvents.Dispatch(w, r, r.Header.Get("X-GitHub-Event"), body)
+}
+
+func handleGitHubPing(w http.ResponseWriter, r *http.Request, event *types.GitHubPingEvent) {
+	logger.LogInfo("Received GitHub ping for hook %d: %s", event.HookID, event.Zen)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("pong"))
+}
+
+func handleGitHubPullRequest(w http.ResponseWriter, r *http.Request, event *types.PRWebhook) {
+	logger.LogWebhook("pull_request", event.Action, event)
+
+	// Review opened PRs and new pushes, and re-evaluate the policy when a
+	// PR leaves draft, its title changes or a policy label is toggled
+	switch event.Action {
+	case "opened", "reopened", "synchronize", "ready_for_review":
+	case "labeled", "unlabeled":
+		if event.Label == nil || !reviewPolicy.IsTriggerLabel(event.Label.Name) {
+			logger.LogInfo("Skipping PR #%d: label change does not affect the review policy", event.PullRequest.Number)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	case "edited":
+		if event.Changes.Title == nil {
+			logger.LogInfo("Skipping PR #%d: edit does not change the title", event.PullRequest.Number)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	default:
+		logger.LogInfo("Skipping PR #%d: action is %s", event.PullRequest.Number, event.Action)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	pr := policy.PullRequest{
+		Draft:        event.PullRequest.Draft,
+		Title:        event.PullRequest.Title,
+		TargetBranch: event.PullRequest.Base.Ref,
+		Author:       event.PullRequest.User.Login,
+		DiffLines:    event.PullRequest.Additions + event.PullRequest.Deletions,
+	}
+	for _, label := range event.PullRequest.Labels {
+		pr.Labels = append(pr.Labels, label.Name)
+	}
+	if ok, reason := reviewPolicy.Evaluate(pr); !ok {
+		logger.LogInfo("Skipping PR #%d: %s", event.PullRequest.Number, reason)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Skip reviews of a head SHA that is already running or done
+	if !dedupStore.BeginReview(requestHost(r), event.Repository.FullName, event.PullRequest.Number, event.PullRequest.Head.SHA) {
+		logger.LogInfo("Skipping PR #%d: review for %s already running or done", event.PullRequest.Number, event.PullRequest.Head.SHA)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Queue PR for review
+	job := &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: event.PullRequest.Number,
+		Repo:     event.Repository.FullName,
+		HeadSHA:  event.PullRequest.Head.SHA,
+		Title:    event.PullRequest.Title,
+		URL:      event.PullRequest.URL,
+
+		InstallationID: event.Installation.ID,
+	}
+	if err := reviewQueue.Enqueue(job); err != nil {
+		logger.LogError("Failed to queue PR review", err)
+		dedupStore.AbandonReview(job.Provider, job.Repo, job.PRNumber, job.HeadSHA)
+		dedupStore.ForgetDelivery(deliveryID(r))
+		http.Error(w, "Failed to queue review", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleGitHubReviewComment(w http.ResponseWriter, r *http.Request, event *types.GitHubReviewCommentEvent) {
+	logger.LogWebhook("pull_request_review_comment", event.Action, event)
+
+	// Only new replies from someone other than the bot can continue a thread
+	if event.Action != "created" || event.Comment.InReplyToID == 0 || isGitHubBot(event.Comment.User) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	enqueueCommand(w, r, &command.Command{Action: command.ActionReply}, &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: event.PullRequest.Number,
+		Repo:     event.Repository.FullName,
+		Title:    event.PullRequest.Title,
+		URL:      event.PullRequest.URL,
+		ThreadID: strconv.FormatInt(event.Comment.InReplyToID, 10),
+		Author:   event.Comment.User.Login,
+
+		InstallationID: event.Installation.ID,
+	})
+}
+
+func handleGitHubIssueComment(w http.ResponseWriter, r *http.Request, event *types.GitHubIssueCommentEvent) {
+	logger.LogWebhook("issue_comment", event.Action, event)
+
+	// Only new comments on PRs from someone other than the bot can hold commands
+	if event.Action != "created" || !event.IsPullRequest() || isGitHubBot(event.Comment.User) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	cmd, ok := command.Parse(event.Comment.Body)
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	enqueueCommand(w, r, cmd, &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: event.Issue.Number,
+		Repo:     event.Repository.FullName,
+		Title:    event.Issue.Title,
+		URL:      event.Issue.URL,
+		Author:   event.Comment.User.Login,
+
+		InstallationID: event.Installation.ID,
+	})
+}
+
+// isGitHubBot reports whether a GitHub user is a bot, including this one
+func isGitHubBot(user types.GitHubUser) bool {
+	return user.Type == "Bot" || (user.Login != "" && user.Login == os.Getenv("GITHUB_BOT_USERNAME"))
+}
+
+func handleGitHubCheckSuite(w http.ResponseWriter, r *http.Request, event *types.GitHubCheckSuiteEvent) {
+	logger.LogWebhook("check_suite", event.Action, event)
+	w.WriteHeader(http.StatusOK)
+}
@@ -1,10 +1,17 @@
 package gitlab
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"sync"
 
+	diffutil "pr-agent-reviewer/diff"
+	"pr-agent-reviewer/httpclient"
 	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/sticky"
+	"pr-agent-reviewer/types"
 
 	"github.com/xanzy/go-gitlab"
 )
@@ -12,6 +19,26 @@ import (
 // Client represents a GitLab client
 type Client struct {
 	client *gitlab.Client
+	// commitStatus is set when reviews are reported as commit statuses
+	commitStatus bool
+
+	// username is the bot's own username, looked up once
+	usernameMu sync.Mutex
+	username   string
+}
+
+// Config configures a client for gitlab.com or a self-managed GitLab
+// instance
+type Config struct {
+	// URL is the instance URL, e.g. https://gitlab.example.com. It is empty
+	// for gitlab.com.
+	URL   string
+	Token string
+	// CAFile is a PEM bundle of additional CAs to trust
+	CAFile string
+	// CommitStatus reports reviews as an external commit status on the MR
+	// head
+	CommitStatus bool
 }
 
 // NewClient creates a new GitLab client
@@ -22,46 +49,445 @@ func NewClient() *Client {
 		return nil
 	}
 
-	client, err := gitlab.NewClient(token)
+	return NewClientWithConfig(Config{
+		URL:          os.Getenv("GITLAB_URL"),
+		Token:        token,
+		CAFile:       os.Getenv("GITLAB_CA_FILE"),
+		CommitStatus: os.Getenv("GITLAB_COMMIT_STATUS") != "false",
+	})
+}
+
+// NewClientWithConfig creates a new GitLab client for the given host
+func NewClientWithConfig(cfg Config) *Client {
+	if cfg.Token == "" {
+		logger.LogError("GitLab token is not set", nil)
+		return nil
+	}
+
+	httpClient, err := httpclient.New(cfg.CAFile)
+	if err != nil {
+		logger.LogError("Failed to configure GitLab HTTP client", err)
+		return nil
+	}
+
+	options := []gitlab.ClientOptionFunc{gitlab.WithHTTPClient(httpClient)}
+	if cfg.URL != "" {
+		options = append(options, gitlab.WithBaseURL(cfg.URL))
+	}
+
+	client, err := gitlab.NewClient(cfg.Token, options...)
 	if err != nil {
 		logger.LogError("Failed to create GitLab client", err)
 		return nil
 	}
 
-	return &Client{client: client}
+	return &Client{client: client, commitStatus: cfg.CommitStatus}
 }
 
 // GetChanges implements the vcs.Provider interface
-func (c *Client) GetChanges(repo string, mrNumber int) ([]string, error) {
+func (c *Client) GetChanges(ctx context.Context, repo string, mrNumber int) ([]types.FileChange, error) {
 	logger.LogInfo("Getting changes for MR #%d in %s", mrNumber, repo)
 
-	diffs, _, err := c.client.MergeRequests.ListMergeRequestDiffs(repo, mrNumber, &gitlab.ListMergeRequestDiffsOptions{})
+	diffs, err := c.listDiffs(ctx, repo, mrNumber)
 	if err != nil {
 		logger.LogError("Failed to get MR changes", err)
-		return nil, fmt.Errorf("failed to get MR changes: %v", err)
+		return nil, fmt.Errorf("failed to get MR changes: %w", err)
+	}
+
+	// GitLab leaves out the diffs of files over its diff limits. Reading
+	// the raw diffs from the repository bypasses the limits.
+	if missingDiffs(diffs) {
+		mr, _, err := c.client.MergeRequests.GetMergeRequestChanges(repo, mrNumber, &gitlab.GetMergeRequestChangesOptions{
+			AccessRawDiffs: gitlab.Bool(true),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			logger.LogError(fmt.Sprintf("Failed to get the raw diffs of MR #%d", mrNumber), err)
+		} else {
+			diffs = fillDiffs(diffs, mr.Changes)
+		}
+	}
+
+	return fileChanges(diffs), nil
+}
+
+// listDiffs returns the diffs of all files changed by an MR
+func (c *Client) listDiffs(ctx context.Context, repo string, mrNumber int) ([]*gitlab.MergeRequestDiff, error) {
+	var diffs []*gitlab.MergeRequestDiff
+	opts := &gitlab.ListMergeRequestDiffsOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := c.client.MergeRequests.ListMergeRequestDiffs(repo, mrNumber, opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, page...)
+
+		if resp.NextPage == 0 {
+			return diffs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// GetChangesBetween implements the vcs.Provider interface
+func (c *Client) GetChangesBetween(ctx context.Context, repo string, mrNumber int, fromSHA, toSHA string) ([]types.FileChange, error) {
+	logger.LogInfo("Getting changes for MR #%d in %s between %s and %s", mrNumber, repo, fromSHA, toSHA)
+
+	comparison, _, err := c.client.Repositories.Compare(repo, &gitlab.CompareOptions{
+		From: gitlab.String(fromSHA),
+		To:   gitlab.String(toSHA),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to compare commits", err)
+		return nil, fmt.Errorf("failed to compare commits: %w", err)
+	}
+
+	diffs := make([]*gitlab.MergeRequestDiff, 0, len(comparison.Diffs))
+	for _, diff := range comparison.Diffs {
+		diffs = append(diffs, &gitlab.MergeRequestDiff{
+			OldPath:     diff.OldPath,
+			NewPath:     diff.NewPath,
+			AMode:       diff.AMode,
+			BMode:       diff.BMode,
+			Diff:        diff.Diff,
+			NewFile:     diff.NewFile,
+			RenamedFile: diff.RenamedFile,
+			DeletedFile: diff.DeletedFile,
+		})
 	}
 
-	var changes []string
+	return fileChanges(diffs), nil
+}
+
+// missingDiffs reports whether GitLab left out the diff of a changed file
+func missingDiffs(diffs []*gitlab.MergeRequestDiff) bool {
+	for _, diff := range diffs {
+		if diffMissing(diff) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffMissing reports whether a file has no diff although its content
+// changed. Renames and mode changes alone have no diff.
+func diffMissing(diff *gitlab.MergeRequestDiff) bool {
+	if diff.Diff != "" {
+		return false
+	}
+	return diff.NewFile || diff.DeletedFile || (!diff.RenamedFile && diff.AMode == diff.BMode)
+}
+
+// fillDiffs fills in missing diffs from the raw diffs of the same files
+func fillDiffs(diffs, raw []*gitlab.MergeRequestDiff) []*gitlab.MergeRequestDiff {
+	byPath := make(map[string]string, len(raw))
+	for _, diff := range raw {
+		byPath[diff.NewPath] = diff.Diff
+	}
 	for _, diff := range diffs {
-		changes = append(changes, fmt.Sprintf("File: %s\nPatch:\n%s", diff.NewPath, diff.Diff))
+		if diff.Diff == "" {
+			diff.Diff = byPath[diff.NewPath]
+		}
+	}
+	return diffs
+}
+
+// GetHeadSHA implements the vcs.Provider interface
+func (c *Client) GetHeadSHA(ctx context.Context, repo string, mrNumber int) (string, error) {
+	mr, _, err := c.client.MergeRequests.GetMergeRequest(repo, mrNumber, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to get MR details", err)
+		return "", fmt.Errorf("failed to get MR details: %w", err)
 	}
+	return mr.SHA, nil
+}
 
-	return changes, nil
+// fileChanges converts the diffs of changed files. Changed files without a
+// diff are too large to diff.
+func fileChanges(diffs []*gitlab.MergeRequestDiff) []types.FileChange {
+	changes := make([]types.FileChange, 0, len(diffs))
+	for _, diff := range diffs {
+		change := diffutil.NewFileChange(diff.NewPath, diff.Diff)
+		change.OldPath = diff.OldPath
+		switch {
+		case diff.NewFile:
+			change.Status = types.FileAdded
+		case diff.DeletedFile:
+			change.Status = types.FileRemoved
+		case diff.RenamedFile:
+			change.Status = types.FileRenamed
+		}
+		change.TooLarge = diffMissing(diff)
+		changes = append(changes, change)
+	}
+	return changes
 }
 
 // CreateReview implements the vcs.Provider interface
-func (c *Client) CreateReview(repo string, mrNumber int, review string) error {
+func (c *Client) CreateReview(ctx context.Context, repo string, mrNumber int, review *types.Review) error {
 	logger.LogInfo("Creating review for MR #%d in %s", mrNumber, repo)
 
-	note := &gitlab.CreateMergeRequestNoteOptions{
-		Body: gitlab.String(review),
+	username, err := c.botUsername(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Earlier findings on lines changed since are superseded by this review.
+	// Failing to mark them does not stop the new review from being posted.
+	if err := c.markFindingsOutdated(ctx, repo, mrNumber, username, review.HeadSHA); err != nil {
+		logger.LogError("Failed to mark earlier findings outdated", err)
+	}
+
+	if err := c.upsertSummary(ctx, repo, mrNumber, username, review, review.Body); err != nil {
+		return err
+	}
+
+	// The summary is posted, so findings that cannot be posted as
+	// discussions on their lines are added elsewhere rather than failing the
+	// review: blocking ones as discussions on the MR, so that they still
+	// have to be resolved, and the others to the summary
+	if len(review.Findings) > 0 {
+		var unposted []types.Finding
+		for _, finding := range c.createDiscussions(ctx, repo, mrNumber, review.Findings) {
+			if finding.Blocking {
+				err := c.createDiscussion(ctx, repo, mrNumber, finding, nil)
+				if err == nil {
+					continue
+				}
+				logger.LogError(fmt.Sprintf("Failed to create discussion for %s:%d", finding.File, finding.Line), err)
+			}
+			unposted = append(unposted, finding)
+		}
+		if len(unposted) > 0 {
+			if err := c.upsertSummary(ctx, repo, mrNumber, username, review, review.Body+types.FormatFindings("Findings", unposted)); err != nil {
+				logger.LogError("Failed to post findings that could not be anchored", err)
+			}
+		}
 	}
 
-	_, _, err := c.client.Notes.CreateMergeRequestNote(repo, mrNumber, note)
+	c.submitVerdict(ctx, repo, mrNumber, review.Verdict)
+	return nil
+}
+
+// submitVerdict approves the MR, or withdraws the bot's approval for any
+// other verdict, so that an approval of an earlier head does not outlive a
+// review that no longer approves. Failures are logged, since the review
+// itself is already posted.
+func (c *Client) submitVerdict(ctx context.Context, repo string, mrNumber int, verdict types.Verdict) {
+	switch verdict {
+	case types.VerdictApprove:
+		logger.LogInfo("Approving MR #%d in %s", mrNumber, repo)
+		_, resp, err := c.client.MergeRequestApprovals.ApproveMergeRequest(repo, mrNumber, nil, gitlab.WithContext(ctx))
+		// GitLab answers 401 when the bot has already approved
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusUnauthorized) {
+			logger.LogError("Failed to approve MR", err)
+		}
+	default:
+		logger.LogInfo("Withdrawing approval of MR #%d in %s", mrNumber, repo)
+		resp, err := c.client.MergeRequestApprovals.UnapproveMergeRequest(repo, mrNumber, gitlab.WithContext(ctx))
+		// GitLab answers 404 when the bot has not approved
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			logger.LogError("Failed to unapprove MR", err)
+		}
+	}
+}
+
+// createDiscussions opens a discussion positioned on the line of each
+// finding and returns the findings GitLab rejected. Discussions of findings
+// that do not block the MR are resolved right away, so that only blocking
+// findings hold up a merge when all threads must be resolved.
+func (c *Client) createDiscussions(ctx context.Context, repo string, mrNumber int, findings []types.Finding) []types.Finding {
+	mr, _, err := c.client.MergeRequests.GetMergeRequest(repo, mrNumber, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to get MR diff refs", err)
+		return findings
+	}
+
+	// Positions name the old path too, which differs for renamed files
+	oldPaths := make(map[string]string)
+	diffs, err := c.listDiffs(ctx, repo, mrNumber)
+	if err != nil {
+		logger.LogError("Failed to get MR changes", err)
+	}
+	for _, diff := range diffs {
+		oldPaths[diff.NewPath] = diff.OldPath
+	}
+
+	var rejected []types.Finding
+	for _, finding := range findings {
+		oldPath := finding.File
+		if path, ok := oldPaths[finding.File]; ok {
+			oldPath = path
+		}
+
+		position := &gitlab.PositionOptions{
+			BaseSHA:      gitlab.String(mr.DiffRefs.BaseSha),
+			StartSHA:     gitlab.String(mr.DiffRefs.StartSha),
+			HeadSHA:      gitlab.String(mr.DiffRefs.HeadSha),
+			PositionType: gitlab.String("text"),
+			NewPath:      gitlab.String(finding.File),
+			OldPath:      gitlab.String(oldPath),
+			NewLine:      gitlab.Int(finding.Line),
+		}
+		// Unchanged lines are identified by their line on both sides
+		if finding.OldLine != 0 {
+			position.OldLine = gitlab.Int(finding.OldLine)
+		}
+
+		if err := c.createDiscussion(ctx, repo, mrNumber, finding, position); err != nil {
+			logger.LogError(fmt.Sprintf("Failed to create discussion on %s:%d", finding.File, finding.Line), err)
+			rejected = append(rejected, finding)
+		}
+	}
+	return rejected
+}
+
+// createDiscussion opens a discussion about a finding, on its line when
+// position is set and on the MR otherwise. The discussion is resolved right
+// away unless the finding is blocking.
+func (c *Client) createDiscussion(ctx context.Context, repo string, mrNumber int, finding types.Finding, position *gitlab.PositionOptions) error {
+	// Suggestions are anchored on the last line and reach back to the
+	// first line of the range. Without a position they are plain code.
+	var body string
+	if position != nil {
+		body = finding.CommentBody(fmt.Sprintf("suggestion:-%d+0", finding.Line-finding.FirstLine()))
+	} else {
+		body = fmt.Sprintf("`%s:%d`\n\n%s", finding.File, finding.Line, finding.CommentBody(""))
+	}
+
+	discussion, _, err := c.client.Discussions.CreateMergeRequestDiscussion(repo, mrNumber, &gitlab.CreateMergeRequestDiscussionOptions{
+		Body:     gitlab.String(sticky.Finding(body, finding.Blocking)),
+		Position: position,
+	}, gitlab.WithContext(ctx))
 	if err != nil {
-		logger.LogError("Failed to create MR review", err)
-		return fmt.Errorf("failed to create MR review: %v", err)
+		return fmt.Errorf("failed to create MR discussion: %w", err)
+	}
+
+	if !finding.Blocking {
+		_, _, err = c.client.Discussions.ResolveMergeRequestDiscussion(repo, mrNumber, discussion.ID, &gitlab.ResolveMergeRequestDiscussionOptions{
+			Resolved: gitlab.Bool(true),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			logger.LogError("Failed to resolve non-blocking discussion", err)
+		}
+	}
+	return nil
+}
+
+// PostComment implements the vcs.Provider interface. The thread ID is the ID
+// of the discussion to reply to.
+func (c *Client) PostComment(ctx context.Context, repo string, mrNumber int, threadID, body string) error {
+	if threadID == "" {
+		logger.LogInfo("Posting note on MR #%d in %s", mrNumber, repo)
+		_, _, err := c.client.Notes.CreateMergeRequestNote(repo, mrNumber, &gitlab.CreateMergeRequestNoteOptions{
+			Body: gitlab.String(body),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			logger.LogError("Failed to post MR note", err)
+			return fmt.Errorf("failed to post MR note: %w", err)
+		}
+		return nil
 	}
 
+	logger.LogInfo("Replying to discussion %s on MR #%d in %s", threadID, mrNumber, repo)
+	_, _, err := c.client.Discussions.AddMergeRequestDiscussionNote(repo, mrNumber, threadID, &gitlab.AddMergeRequestDiscussionNoteOptions{
+		Body: gitlab.String(body),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to reply to MR discussion", err)
+		return fmt.Errorf("failed to reply to MR discussion: %w", err)
+	}
 	return nil
-} 
\ No newline at end of file
+}
+
+// IsCollaborator implements the vcs.Provider interface. Users need at least
+// Developer access to the project, directly or through a group.
+func (c *Client) IsCollaborator(ctx context.Context, repo, username string) (bool, error) {
+	members, _, err := c.client.ProjectMembers.ListAllProjectMembers(repo, &gitlab.ListProjectMembersOptions{
+		Query: gitlab.String(username),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to list project members", err)
+		return false, fmt.Errorf("failed to list project members: %w", err)
+	}
+
+	for _, member := range members {
+		if member.Username == username {
+			return member.AccessLevel >= gitlab.DeveloperPermissions, nil
+		}
+	}
+	return false, nil
+}
+
+// GetThread implements the vcs.Provider interface. The thread ID is the ID of
+// the discussion.
+func (c *Client) GetThread(ctx context.Context, repo string, mrNumber int, threadID string) (*types.ReviewThread, error) {
+	logger.LogInfo("Getting discussion %s on MR #%d in %s", threadID, mrNumber, repo)
+
+	username, err := c.botUsername(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	discussion, _, err := c.client.Discussions.GetMergeRequestDiscussion(repo, mrNumber, threadID, gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to get MR discussion", err)
+		return nil, fmt.Errorf("failed to get MR discussion: %w", err)
+	}
+
+	thread := &types.ReviewThread{ID: threadID}
+	var position *gitlab.NotePosition
+	for _, note := range discussion.Notes {
+		if note.System {
+			continue
+		}
+		if position == nil && note.Position != nil {
+			position = note.Position
+		}
+		thread.Comments = append(thread.Comments, types.ThreadComment{
+			Author: note.Author.Username,
+			Body:   note.Body,
+			Own:    note.Author.Username == username,
+		})
+	}
+
+	// GitLab does not return the diff hunk of a diff note, so cut it from
+	// the MR diff
+	if position != nil && position.NewPath != "" {
+		thread.File = position.NewPath
+		diffs, err := c.listDiffs(ctx, repo, mrNumber)
+		if err != nil {
+			logger.LogError("Failed to get MR changes", err)
+			return nil, fmt.Errorf("failed to get MR changes: %w", err)
+		}
+		for _, diff := range diffs {
+			if diff.NewPath == position.NewPath {
+				thread.DiffHunk = diffutil.HunkAt(diff.Diff, position.NewLine)
+				break
+			}
+		}
+	}
+
+	return thread, nil
+}
+
+// botUsername returns the username the client posts as: GITLAB_BOT_USERNAME
+// when set, otherwise the owner of the token
+func (c *Client) botUsername(ctx context.Context) (string, error) {
+	if username := os.Getenv("GITLAB_BOT_USERNAME"); username != "" {
+		return username, nil
+	}
+
+	c.usernameMu.Lock()
+	defer c.usernameMu.Unlock()
+	if c.username != "" {
+		return c.username, nil
+	}
+
+	user, _, err := c.client.Users.CurrentUser(gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to get current user", err)
+		return "", fmt.Errorf("failed to get current user: %w", err)
+	}
+	c.username = user.Username
+	return c.username, nil
+}
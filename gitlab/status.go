@@ -0,0 +1,82 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/types"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// statusName is the name of the commit status the bot sets on MR heads
+const statusName = "ai-review"
+
+// maxStatusDescription is the length GitLab keeps of a status description
+const maxStatusDescription = 255
+
+// StartCheck implements the vcs.CheckReporter interface by setting a running
+// external commit status on the head of the MR. The check ID is the head SHA.
+func (c *Client) StartCheck(ctx context.Context, repo string, mrNumber int, headSHA string) (string, error) {
+	if !c.commitStatus {
+		return "", nil
+	}
+
+	if headSHA == "" {
+		var err error
+		if headSHA, err = c.GetHeadSHA(ctx, repo, mrNumber); err != nil {
+			return "", err
+		}
+	}
+
+	logger.LogInfo("Setting running commit status for MR #%d in %s at %s", mrNumber, repo, headSHA)
+	if err := c.setCommitStatus(ctx, repo, mrNumber, headSHA, gitlab.Running, "Review in progress"); err != nil {
+		return "", err
+	}
+	return headSHA, nil
+}
+
+// CompleteCheck implements the vcs.CheckReporter interface. Only a review
+// that requests changes or failed fails the status; GitLab has no neutral
+// state, and a skipped status would hold up MRs that need pipelines to
+// succeed.
+func (c *Client) CompleteCheck(ctx context.Context, repo string, mrNumber int, checkID string, result *types.CheckResult) error {
+	state := gitlab.Success
+	switch result.Conclusion {
+	case types.CheckFailure:
+		state = gitlab.Failed
+	case types.CheckCancelled:
+		state = gitlab.Canceled
+	}
+
+	logger.LogInfo("Setting %s commit status for MR #%d in %s at %s", state, mrNumber, repo, checkID)
+	return c.setCommitStatus(ctx, repo, mrNumber, checkID, state, result.Title)
+}
+
+// setCommitStatus sets the bot's commit status on a commit, linking to the MR
+func (c *Client) setCommitStatus(ctx context.Context, repo string, mrNumber int, sha string, state gitlab.BuildStateValue, description string) error {
+	if len(description) > maxStatusDescription {
+		description = description[:maxStatusDescription]
+	}
+
+	opts := &gitlab.SetCommitStatusOptions{
+		State:       state,
+		Name:        gitlab.String(statusName),
+		Description: gitlab.String(description),
+	}
+	mr, _, err := c.client.MergeRequests.GetMergeRequest(repo, mrNumber, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to get MR details", err)
+	} else {
+		opts.TargetURL = gitlab.String(mr.WebURL)
+		opts.Ref = gitlab.String(mr.SourceBranch)
+	}
+
+	_, _, err = c.client.Commits.SetCommitStatus(repo, sha, opts, gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to set commit status", err)
+		return fmt.Errorf("failed to set commit status: %w", err)
+	}
+	return nil
+}
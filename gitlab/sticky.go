@@ -0,0 +1,164 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	diffutil "pr-agent-reviewer/diff"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/sticky"
+	"pr-agent-reviewer/types"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// upsertSummary adds a review round to the bot's summary note on an MR,
+// creating the note if the bot has not posted one yet
+func (c *Client) upsertSummary(ctx context.Context, repo string, mrNumber int, username string, review *types.Review, text string) error {
+	existing, err := c.findSummary(ctx, repo, mrNumber, username)
+	if err != nil {
+		return err
+	}
+
+	heading := sticky.Heading(review.HeadSHA, time.Now())
+	if existing == nil {
+		logger.LogInfo("Creating summary note on MR #%d in %s", mrNumber, repo)
+		_, _, err := c.client.Notes.CreateMergeRequestNote(repo, mrNumber, &gitlab.CreateMergeRequestNoteOptions{
+			Body: gitlab.String(sticky.Compose("", review.ID, heading, text)),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			logger.LogError("Failed to create summary note", err)
+			return fmt.Errorf("failed to create summary note: %w", err)
+		}
+		return nil
+	}
+
+	logger.LogInfo("Updating summary note %d on MR #%d in %s", existing.ID, mrNumber, repo)
+	_, _, err = c.client.Notes.UpdateMergeRequestNote(repo, mrNumber, existing.ID, &gitlab.UpdateMergeRequestNoteOptions{
+		Body: gitlab.String(sticky.Compose(existing.Body, review.ID, heading, text)),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		logger.LogError("Failed to update summary note", err)
+		return fmt.Errorf("failed to update summary note: %w", err)
+	}
+	return nil
+}
+
+// findSummary returns the bot's summary note on an MR, or nil if there is
+// none
+func (c *Client) findSummary(ctx context.Context, repo string, mrNumber int, username string) (*gitlab.Note, error) {
+	opts := &gitlab.ListMergeRequestNotesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		OrderBy:     gitlab.String("created_at"),
+		Sort:        gitlab.String("asc"),
+	}
+	for {
+		notes, resp, err := c.client.Notes.ListMergeRequestNotes(repo, mrNumber, opts, gitlab.WithContext(ctx))
+		if err != nil {
+			logger.LogError("Failed to list MR notes", err)
+			return nil, fmt.Errorf("failed to list MR notes: %w", err)
+		}
+
+		for _, note := range notes {
+			if !note.System && note.Author.Username == username && sticky.IsSummary(note.Body) {
+				return note, nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// markFindingsOutdated collapses the bot's findings from earlier reviews of
+// an MR whose lines have changed since the commit they were made on, and
+// resolves their discussions. Findings on unchanged lines still apply and are
+// left open, and discussions of blocking findings are never resolved by the
+// bot.
+func (c *Client) markFindingsOutdated(ctx context.Context, repo string, mrNumber int, username, headSHA string) error {
+	opts := &gitlab.ListMergeRequestDiscussionsOptions{PerPage: 100}
+	var findings []*gitlab.Discussion
+	for {
+		discussions, resp, err := c.client.Discussions.ListMergeRequestDiscussions(repo, mrNumber, opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("failed to list MR discussions: %w", err)
+		}
+
+		for _, discussion := range discussions {
+			if len(discussion.Notes) == 0 {
+				continue
+			}
+			first := discussion.Notes[0]
+			if first.Author.Username == username && sticky.IsFinding(first.Body) && first.Position != nil {
+				findings = append(findings, discussion)
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+
+	if headSHA == "" {
+		var err error
+		if headSHA, err = c.GetHeadSHA(ctx, repo, mrNumber); err != nil {
+			return err
+		}
+	}
+
+	changesSince := make(map[string][]types.FileChange)
+	var outdated []*gitlab.Discussion
+	for _, discussion := range findings {
+		position := discussion.Notes[0].Position
+		if position.HeadSHA == headSHA {
+			continue
+		}
+		changes, ok := changesSince[position.HeadSHA]
+		if !ok {
+			var err error
+			if changes, err = c.GetChangesBetween(ctx, repo, mrNumber, position.HeadSHA, headSHA); err != nil {
+				return err
+			}
+			changesSince[position.HeadSHA] = changes
+		}
+
+		start, end := position.NewLine, position.NewLine
+		if r := position.LineRange; r != nil && r.StartRange != nil && r.StartRange.NewLine > 0 {
+			start = r.StartRange.NewLine
+		}
+		if diffutil.Touched(changes, position.NewPath, start, end) {
+			outdated = append(outdated, discussion)
+		}
+	}
+
+	for _, discussion := range outdated {
+		first := discussion.Notes[0]
+		blocking := sticky.IsBlocking(first.Body)
+		_, _, err := c.client.Discussions.UpdateMergeRequestDiscussionNote(repo, mrNumber, discussion.ID, first.ID, &gitlab.UpdateMergeRequestDiscussionNoteOptions{
+			Body: gitlab.String(sticky.Outdated(first.Body)),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("failed to update discussion %s: %w", discussion.ID, err)
+		}
+
+		if first.Resolvable && !first.Resolved && !blocking {
+			_, _, err = c.client.Discussions.ResolveMergeRequestDiscussion(repo, mrNumber, discussion.ID, &gitlab.ResolveMergeRequestDiscussionOptions{
+				Resolved: gitlab.Bool(true),
+			}, gitlab.WithContext(ctx))
+			if err != nil {
+				return fmt.Errorf("failed to resolve discussion %s: %w", discussion.ID, err)
+			}
+		}
+	}
+	if len(outdated) > 0 {
+		logger.LogInfo("Marked %d earlier findings outdated on MR #%d in %s", len(outdated), mrNumber, repo)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"pr-agent-reviewer/command"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/policy"
+	"pr-agent-reviewer/queue"
+	"pr-agent-reviewer/types"
+	"pr-agent-reviewer/webhook"
+)
+
+// gitlabEvents routes GitLab webhooks by the object_kind of their payload
+var gitlabEvents = webhook.NewDispatcher("GitLab")
+
+func init() {
+	gitlabEvents.Register("merge_request", webhook.Typed(handleGitLabMergeRequest))
+	gitlabEvents.Register("note", webhook.Typed(handleGitLabNote))
+}
+
+func handleGitLabWebhook(w http.ResponseWriter, r *http.Request, body []byte) {
+	var event struct {
+		ObjectKind string `json:"object_kind"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		logger.LogError("Failed to decode GitLab webhook payload", err)
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	gitlabEvents.Dispatch(w, r, event.ObjectKind, body)
+}
+
+func handleGitLabMergeRequest(w http.ResponseWriter, r *http.Request, event *types.GitLabWebhook) {
+	logger.LogWebhook("merge_request", event.ObjectAttributes.Action, event)
+
+	// Review opened MRs and updates that push new commits, and re-evaluate
+	// the policy when an MR leaves draft, its title changes or a policy
+	// label is toggled
+	action := event.ObjectAttributes.Action
+	newCommits := action == "update" && event.ObjectAttributes.OldRev != ""
+	policyChanged := action == "update" && gitlabPolicyChanged(event)
+	if action != "open" && action != "reopen" && !newCommits && !policyChanged {
+		logger.LogInfo("Skipping MR #%d: action is %s", event.ObjectAttributes.IID, event.ObjectAttributes.Action)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// The MR payload does not carry the diff size, so size limits are
+	// checked once the changes are fetched
+	pr := policy.PullRequest{
+		Draft:        event.ObjectAttributes.Draft || event.ObjectAttributes.WorkInProgress,
+		Labels:       gitlabLabels(event.Labels),
+		Title:        event.ObjectAttributes.Title,
+		TargetBranch: event.ObjectAttributes.TargetBranch,
+		Author:       event.User.Username,
+		DiffLines:    -1,
+	}
+	if ok, reason := reviewPolicy.Evaluate(pr); !ok {
+		logger.LogInfo("Skipping MR #%d: %s", event.ObjectAttributes.IID, reason)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Skip reviews of a head SHA that is already running or done
+	if !dedupStore.BeginReview(requestHost(r), event.Project.PathWithNamespace, event.ObjectAttributes.IID, event.ObjectAttributes.LastCommit.ID) {
+		logger.LogInfo("Skipping MR #%d: review for %s already running or done", event.ObjectAttributes.IID, event.ObjectAttributes.LastCommit.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Queue MR for review
+	job := &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: event.ObjectAttributes.IID,
+		Repo:     event.Project.PathWithNamespace,
+		HeadSHA:  event.ObjectAttributes.LastCommit.ID,
+		Title:    event.ObjectAttributes.Title,
+		URL:      event.ObjectAttributes.URL,
+	}
+	if err := reviewQueue.Enqueue(job); err != nil {
+		logger.LogError("Failed to queue MR review", err)
+		dedupStore.AbandonReview(job.Provider, job.Repo, job.PRNumber, job.HeadSHA)
+		dedupStore.ForgetDelivery(deliveryID(r))
+		http.Error(w, "Failed to queue review", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// gitlabPolicyChanged reports whether an MR update changed anything the
+// review policy decides on
+func gitlabPolicyChanged(event *types.GitLabWebhook) bool {
+	if event.Changes.Draft != nil || event.Changes.Title != nil {
+		return true
+	}
+	if event.Changes.Labels != nil {
+		changed := policy.ChangedLabels(gitlabLabels(event.Changes.Labels.Previous), gitlabLabels(event.Changes.Labels.Current))
+		for _, label := range changed {
+			if reviewPolicy.IsTriggerLabel(label) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func gitlabLabels(labels []types.GitLabLabel) []string {
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.Title)
+	}
+	return names
+}
+
+func handleGitLabNote(w http.ResponseWriter, r *http.Request, event *types.GitLabNoteEvent) {
+	logger.LogWebhook("note", event.ObjectAttributes.NoteableType, event)
+
+	// Only merge request notes from someone other than the bot can hold commands
+	if !event.IsMergeRequest() || event.User.Username == os.Getenv("GITLAB_BOT_USERNAME") {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	cmd, ok := command.Parse(event.ObjectAttributes.Note)
+	if !ok {
+		// Notes in a discussion may be replies to a thread the bot started
+		if event.ObjectAttributes.Type != "DiscussionNote" && event.ObjectAttributes.Type != "DiffNote" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		cmd = &command.Command{Action: command.ActionReply}
+	}
+
+	enqueueCommand(w, r, cmd, &queue.Job{
+		Provider: requestHost(r),
+		PRNumber: event.MergeRequest.IID,
+		Repo:     event.Project.PathWithNamespace,
+		Title:    event.MergeRequest.Title,
+		URL:      event.MergeRequest.URL,
+		ThreadID: event.ObjectAttributes.DiscussionID,
+		Author:   event.User.Username,
+	})
+}
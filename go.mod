@@ -8,6 +8,7 @@ require (
 	github.com/google/go-github/v57 v57.0.0
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
 	github.com/sashabaranov/go-openai v1.19.2
 	github.com/slack-go/slack v0.12.5
 	github.com/xanzy/go-gitlab v0.115.0
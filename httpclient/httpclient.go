@@ -0,0 +1,45 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// New creates an HTTP client for talking to a VCS host. When caFile is set,
+// the PEM certificates in it are trusted in addition to the system roots, for
+// self-hosted instances behind a private CA.
+func New(caFile string) (*http.Client, error) {
+	transport, err := Transport(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport, Timeout: 60 * time.Second}, nil
+}
+
+// Transport returns the round tripper used by New
+func Transport(caFile string) (http.RoundTripper, error) {
+	if caFile == "" {
+		return http.DefaultTransport, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return transport, nil
+}
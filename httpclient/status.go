@@ -0,0 +1,19 @@
+package httpclient
+
+import "fmt"
+
+// StatusError is returned when an API responds with an error status. Retry
+// classification reads its status code through HTTPStatusCode.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// HTTPStatusCode returns the status code of the failed response
+func (e *StatusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
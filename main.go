@@ -2,30 +2,42 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"pr-agent-reviewer/ai"
+	"pr-agent-reviewer/dedup"
 	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/policy"
+	"pr-agent-reviewer/queue"
 	"pr-agent-reviewer/slack"
+	"pr-agent-reviewer/store"
 	"pr-agent-reviewer/vcs"
 
-	"pr-agent-reviewer/types"
-
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
 
 var (
-	vcsProvider vcs.Provider
-	aiProvider  ai.Provider
-	slClient    *slack.Client
+	vcsHosts     *vcs.Registry
+	aiProvider   ai.Provider
+	slClient     *slack.Client
+	reviewQueue  *queue.Queue
+	dedupStore   *dedup.Store
+	reviewStore  store.Store
+	reviewPolicy *policy.Policy
 )
 
 func main() {
@@ -34,13 +46,16 @@ func main() {
 		logger.LogError("Failed to load .env file", err)
 	}
 
-	// Initialize VCS provider
+	// Initialize VCS hosts
 	var err error
-	vcsProvider, err = vcs.NewProvider()
+	vcsHosts, err = vcs.NewRegistry()
 	if err != nil {
-		logger.LogError("Failed to initialize VCS provider", err)
+		logger.LogError("Failed to initialize VCS providers", err)
 		os.Exit(1)
 	}
+	for _, host := range vcsHosts.Hosts() {
+		logger.LogInfo("Serving webhooks for VCS host: %s (%s, %s)", host.Name, host.Type, host.Hostname())
+	}
 	
 	// Initialize AI provider
 	aiProvider, err = ai.NewProvider()
@@ -51,14 +66,50 @@ func main() {
 	
 	slClient = slack.NewClient()
 
+	reviewPolicy = policy.NewPolicy()
+
+	// Initialize dedup store
+	dedupStore, err = dedup.NewStore()
+	if err != nil {
+		logger.LogError("Failed to initialize dedup store", err)
+		os.Exit(1)
+	}
+
+	// Initialize review history store
+	reviewStore, err = store.NewStore()
+	if err != nil {
+		logger.LogError("Failed to initialize review store", err)
+		os.Exit(1)
+	}
+	defer reviewStore.Close()
+
+	// Initialize review queue and start the worker pool
+	reviewQueue, err = queue.NewQueue()
+	if err != nil {
+		logger.LogError("Failed to initialize review queue", err)
+		os.Exit(1)
+	}
+	reviewQueue.Start(runJob)
+
 	// Initialize router
 	r := mux.NewRouter()
 
 	// Middleware for logging
 	r.Use(loggingMiddleware)
 
-	// Webhook endpoint
+	// Webhook endpoints: /webhook detects the provider from the event
+	// headers, /webhook/{provider} names the provider or the host explicitly
 	r.HandleFunc("/webhook", handleWebhook).Methods("POST")
+	r.HandleFunc("/webhook/{provider}", handleWebhook).Methods("POST")
+
+	// Admin endpoints
+	if os.Getenv("ADMIN_TOKEN") != "" {
+		admin := r.PathPrefix("/admin").Subrouter()
+		admin.Use(adminAuthMiddleware)
+		admin.HandleFunc("/dead-letters", handleListDeadLetters).Methods("GET")
+		admin.HandleFunc("/dead-letters/{id}/redrive", handleRedriveDeadLetter).Methods("POST")
+		admin.HandleFunc("/runs", handleListRuns).Methods("GET")
+	}
 
 	// Health check endpoint
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -72,11 +123,43 @@ func main() {
 		port = "8080"
 	}
 
-	logger.LogInfo("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		logger.LogError("Server failed to start", err)
-		os.Exit(1)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		logger.LogInfo("Server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.LogError("Server failed to start", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	// Stop accepting webhooks, then wait for running reviews. Anything left
+	// unfinished stays in the queue directory and is resumed on restart.
+	timeout := 30 * time.Second
+	if value, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && value > 0 {
+		timeout = value
+	}
+	logger.LogInfo("Shutting down (timeout: %v)", timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.LogError("Failed to shut down server gracefully", err)
+	}
+	if err := reviewQueue.Stop(shutdownCtx); err != nil {
+		logger.LogError("Failed to drain review queue", err)
 	}
+	logger.LogInfo("Server stopped")
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -88,17 +171,75 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func handleWebhook(w http.ResponseWriter, r *http.Request) {
-	// Determine the provider type
-	providerType := os.Getenv("VCS_PROVIDER")
-	if providerType == "" {
-		providerType = "github" // Default to GitHub
+func adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := "Bearer " + os.Getenv("ADMIN_TOKEN")
+		if !hmac.Equal([]byte(r.Header.Get("Authorization")), []byte(expected)) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	jobs, err := reviewQueue.DeadLetters()
+	if err != nil {
+		logger.LogError("Failed to list dead-lettered jobs", err)
+		http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+		return
 	}
 
-	// Verify webhook signature based on provider
-	if !verifyWebhookSignature(r, providerType) {
-		logger.LogError("Webhook signature verification failed", nil)
-		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func handleRedriveDeadLetter(w http.ResponseWriter, r *http.Request) {
+	job, err := reviewQueue.Redrive(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		logger.LogError("Failed to re-drive dead-lettered job", err)
+		http.Error(w, "Failed to re-drive job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+func handleListRuns(w http.ResponseWriter, r *http.Request) {
+	provider := r.URL.Query().Get("provider")
+	if provider == "" {
+		provider = string(vcs.ProviderGitHub)
+	}
+	repo := r.URL.Query().Get("repo")
+	prNumber, err := strconv.Atoi(r.URL.Query().Get("pr"))
+	if repo == "" || err != nil {
+		http.Error(w, "repo and pr query parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	runs, err := reviewStore.ListRuns(r.Context(), provider, repo, prNumber)
+	if err != nil {
+		logger.LogError("Failed to list review runs", err)
+		http.Error(w, "Failed to list review runs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	// Determine the provider type
+	providerType, ok := webhookProvider(r)
+	if !ok {
+		logger.LogError("Could not determine the VCS provider of the webhook", nil)
+		http.Error(w, "Unknown VCS provider", http.StatusBadRequest)
 		return
 	}
 
@@ -112,75 +253,174 @@ func handleWebhook(w http.ResponseWriter, r *http.Request) {
 	// Restore the body for later use
 	r.Body = io.NopCloser(bytes.NewBuffer(body))
 
-	if providerType == "gitlab" {
+	// Route the webhook to the host it came from
+	host, ok := webhookHost(r, providerType, body)
+	if !ok {
+		logger.LogInfo("Rejecting webhook for unconfigured %s host %s", providerType, webhookHostname(r, body))
+		http.Error(w, "VCS provider not enabled", http.StatusNotFound)
+		return
+	}
+
+	// Verify webhook signature based on provider
+	if !verifyWebhookSignature(r, host) {
+		logger.LogError("Webhook signature verification failed", nil)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	// Skip deliveries that were already processed
+	delivery := webhookDeliveryID(r, providerType, body)
+	if !dedupStore.MarkDelivery(delivery) {
+		logger.LogInfo("Skipping duplicate webhook delivery %s", delivery)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), webhookHostKey{}, host)
+	r = r.WithContext(context.WithValue(ctx, webhookDeliveryKey{}, delivery))
+	switch providerType {
+	case vcs.ProviderGitLab:
 		handleGitLabWebhook(w, r, body)
-	} else {
+	case vcs.ProviderBitbucket:
+		handleBitbucketWebhook(w, r, body)
+	case vcs.ProviderGitea:
+		handleGiteaWebhook(w, r, body)
+	case vcs.ProviderAzureDevOps:
+		handleAzureDevOpsWebhook(w, r, body)
+	default:
 		handleGitHubWebhook(w, r, body)
 	}
 }
 
-func handleGitHubWebhook(w http.ResponseWriter, r *http.Request, body []byte) {
-	var webhook types.PRWebhook
-	if err := json.Unmarshal(body, &webhook); err != nil {
-		logger.LogError("Failed to decode GitHub webhook payload", err)
-		http.Error(w, "Invalid payload", http.StatusBadRequest)
-		return
+// webhookProvider determines which VCS sent a webhook: from the route when it
+// names the provider or a host, otherwise from the event headers, otherwise
+// the only configured host
+func webhookProvider(r *http.Request) (vcs.ProviderType, bool) {
+	if name, ok := mux.Vars(r)["provider"]; ok {
+		if host, ok := vcsHosts.Get(name); ok {
+			return host.Type, true
+		}
+		return vcs.ProviderType(name), true
 	}
 
-	logger.LogWebhook("pull_request", webhook.Action, webhook)
+	// Gitea also sends X-GitHub-Event for compatibility, so it is checked first
+	switch {
+	case r.Header.Get("X-Gitea-Event") != "":
+		return vcs.ProviderGitea, true
+	case r.Header.Get("X-GitHub-Event") != "":
+		return vcs.ProviderGitHub, true
+	case r.Header.Get("X-Gitlab-Event") != "":
+		return vcs.ProviderGitLab, true
+	case r.Header.Get("X-Event-Key") != "":
+		return vcs.ProviderBitbucket, true
+	}
 
-	// Only process opened PRs
-	if webhook.Action != "opened" && webhook.Action != "reopened" {
-		logger.LogInfo("Skipping PR #%d: action is %s", webhook.PullRequest.Number, webhook.Action)
-		w.WriteHeader(http.StatusOK)
-		return
+	if hosts := vcsHosts.Hosts(); len(hosts) == 1 {
+		return hosts[0].Type, true
 	}
+	return "", false
+}
 
-	// Process PR in a goroutine
-	go processPR(webhook.PullRequest.Number, webhook.Repository.FullName, webhook.PullRequest.Title, webhook.PullRequest.URL)
+type webhookHostKey struct{}
 
-	w.WriteHeader(http.StatusOK)
+// webhookHost determines which configured host sent a webhook: the host the
+// route names, otherwise the host of that type matching the instance in the
+// headers or payload
+func webhookHost(r *http.Request, providerType vcs.ProviderType, body []byte) (*vcs.Host, bool) {
+	if name, ok := mux.Vars(r)["provider"]; ok {
+		if host, ok := vcsHosts.Get(name); ok {
+			return host, true
+		}
+	}
+	return vcsHosts.Resolve(providerType, webhookHostname(r, body))
 }
 
-func handleGitLabWebhook(w http.ResponseWriter, r *http.Request, body []byte) {
-	var webhook types.GitLabWebhook
-	if err := json.Unmarshal(body, &webhook); err != nil {
-		logger.LogError("Failed to decode GitLab webhook payload", err)
-		http.Error(w, "Invalid payload", http.StatusBadRequest)
-		return
+// webhookHostname returns the instance a webhook came from. GitHub Enterprise
+// Server and GitLab name it in a header; otherwise it is taken from the
+// repository or project URL in the payload.
+func webhookHostname(r *http.Request, body []byte) string {
+	if host := r.Header.Get("X-GitHub-Enterprise-Host"); host != "" {
+		return host
 	}
 
-	// Only process merge requests
-	if webhook.ObjectKind != "merge_request" {
-		logger.LogInfo("Skipping non-merge request event: %s", webhook.ObjectKind)
-		w.WriteHeader(http.StatusOK)
-		return
+	var payload struct {
+		Repository struct {
+			HTMLURL string `json:"html_url"`
+		} `json:"repository"`
+		Project struct {
+			WebURL string `json:"web_url"`
+		} `json:"project"`
 	}
+	json.Unmarshal(body, &payload)
 
-	logger.LogWebhook("merge_request", webhook.ObjectAttributes.Action, webhook)
+	for _, rawURL := range []string{r.Header.Get("X-Gitlab-Instance"), payload.Project.WebURL, payload.Repository.HTMLURL} {
+		if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+			return u.Host
+		}
+	}
+	return ""
+}
 
-	// Only process opened MRs
-	if webhook.ObjectAttributes.Action != "open" && webhook.ObjectAttributes.Action != "reopen" {
-		logger.LogInfo("Skipping MR #%d: action is %s", webhook.ObjectAttributes.IID, webhook.ObjectAttributes.Action)
-		w.WriteHeader(http.StatusOK)
-		return
+// requestHost returns the name of the host a webhook came from, which jobs
+// and the review history are keyed on
+func requestHost(r *http.Request) string {
+	host, _ := r.Context().Value(webhookHostKey{}).(*vcs.Host)
+	if host == nil {
+		return ""
 	}
+	return host.Name
+}
+
+type webhookDeliveryKey struct{}
 
-	// Process MR in a goroutine
-	go processPR(webhook.ObjectAttributes.IID, webhook.Project.PathWithNamespace, webhook.ObjectAttributes.Title, webhook.ObjectAttributes.URL)
+// deliveryID returns the ID of the webhook delivery being handled
+func deliveryID(r *http.Request) string {
+	id, _ := r.Context().Value(webhookDeliveryKey{}).(string)
+	return id
+}
 
-	w.WriteHeader(http.StatusOK)
+// webhookDeliveryID returns the unique ID the VCS assigned to a webhook
+// delivery. Azure DevOps service hooks carry no delivery header, so the ID of
+// the event in the payload is used; it stays the same when a failed
+// notification is retried.
+func webhookDeliveryID(r *http.Request, providerType vcs.ProviderType, body []byte) string {
+	if providerType == vcs.ProviderAzureDevOps {
+		var event struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(body, &event); err != nil {
+			return ""
+		}
+		return event.ID
+	}
+	if id := r.Header.Get("X-Gitea-Delivery"); id != "" {
+		return id
+	}
+	if id := r.Header.Get("X-GitHub-Delivery"); id != "" {
+		return id
+	}
+	if id := r.Header.Get("X-Gitlab-Event-UUID"); id != "" {
+		return id
+	}
+	return r.Header.Get("X-Request-UUID")
 }
 
-func verifyWebhookSignature(r *http.Request, providerType string) bool {
-	if providerType == "gitlab" {
-		return verifyGitLabWebhook(r)
+func verifyWebhookSignature(r *http.Request, host *vcs.Host) bool {
+	switch host.Type {
+	case vcs.ProviderGitLab:
+		return verifyGitLabWebhook(r, host.WebhookSecret)
+	case vcs.ProviderBitbucket:
+		return verifyBitbucketWebhook(r, host.WebhookSecret)
+	case vcs.ProviderGitea:
+		return verifyGiteaWebhook(r, host.WebhookSecret)
+	case vcs.ProviderAzureDevOps:
+		return verifyAzureDevOpsWebhook(r, host.WebhookSecret)
+	default:
+		return verifyGitHubWebhook(r, host.WebhookSecret)
 	}
-	return verifyGitHubWebhook(r)
 }
 
-func verifyGitHubWebhook(r *http.Request) bool {
-	secret := os.Getenv("GITHUB_WEBHOOK_SECRET")
+func verifyGitHubWebhook(r *http.Request, secret string) bool {
 	if secret == "" {
 		logger.LogDebug("No GitHub webhook secret set, skipping verification")
 		return true
@@ -213,66 +453,124 @@ func verifyGitHubWebhook(r *http.Request) bool {
 	return isValid
 }
 
-func verifyGitLabWebhook(r *http.Request) bool {
-	token := os.Getenv("GITLAB_WEBHOOK_TOKEN")
-	if token == "" {
-		logger.LogDebug("No GitLab webhook token set, skipping verification")
+func verifyBitbucketWebhook(r *http.Request, secret string) bool {
+	if secret == "" {
+		logger.LogDebug("No Bitbucket webhook secret set, skipping verification")
 		return true
 	}
 
-	signature := r.Header.Get("X-GitLab-Token")
+	signature := r.Header.Get("X-Hub-Signature")
 	if signature == "" {
-		logger.LogError("Missing GitLab webhook token", nil)
+		logger.LogError("Missing Bitbucket webhook signature", nil)
 		return false
 	}
 
-	isValid := signature == token
+	// Read request body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.LogError("Failed to read request body", err)
+		return false
+	}
+	// Restore the body for later use
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	// Calculate expected signature
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expectedSignature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	isValid := hmac.Equal([]byte(signature), []byte(expectedSignature))
 	if !isValid {
-		logger.LogError("Invalid GitLab webhook token", nil)
+		logger.LogError("Invalid Bitbucket webhook signature", nil)
 	}
 	return isValid
 }
 
-func processPR(prNumber int, repo string, title string, url string) {
-	logger.LogPRReview(prNumber, repo, "started")
+func verifyGiteaWebhook(r *http.Request, secret string) bool {
+	if secret == "" {
+		logger.LogDebug("No Gitea webhook secret set, skipping verification")
+		return true
+	}
 
-	// Get PR changes
-	changes, err := vcsProvider.GetChanges(repo, prNumber)
-	if err != nil {
-		logger.LogError("Failed to get PR changes", err)
-		return
+	signature := r.Header.Get("X-Gitea-Signature")
+	if signature == "" {
+		logger.LogError("Missing Gitea webhook signature", nil)
+		return false
 	}
-	logger.LogInfo("Retrieved %d files from PR #%d", len(changes), prNumber)
 
-	// Get AI review
-	review, err := aiProvider.ReviewCode(changes)
+	// Read request body
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		logger.LogError("Failed to get AI review", err)
-		return
+		logger.LogError("Failed to read request body", err)
+		return false
 	}
-	logger.LogInfo("Generated AI review for PR #%d", prNumber)
+	// Restore the body for later use
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
 
-	// Generate review summary
-	summary, err := aiProvider.GenerateReviewSummary(review)
-	if err != nil {
-		logger.LogError("Failed to generate review summary", err)
-		return
+	// Gitea signs with the bare hex digest, without a "sha256=" prefix
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expectedSignature := hex.EncodeToString(mac.Sum(nil))
+
+	isValid := hmac.Equal([]byte(signature), []byte(expectedSignature))
+	if !isValid {
+		logger.LogError("Invalid Gitea webhook signature", nil)
+	}
+	return isValid
+}
+
+// verifyAzureDevOpsWebhook checks the basic auth credentials and the shared
+// secret header configured on the service hook subscription
+func verifyAzureDevOpsWebhook(r *http.Request, secret string) bool {
+	username := os.Getenv("AZURE_DEVOPS_WEBHOOK_USERNAME")
+	password := os.Getenv("AZURE_DEVOPS_WEBHOOK_PASSWORD")
+	if username == "" && password == "" && secret == "" {
+		logger.LogDebug("No Azure DevOps webhook credentials set, skipping verification")
+		return true
 	}
-	logger.LogInfo("Generated review summary for PR #%d", prNumber)
 
-	// Create review
-	if err := vcsProvider.CreateReview(repo, prNumber, review); err != nil {
-		logger.LogError("Failed to create review", err)
-		return
+	if username != "" || password != "" {
+		gotUsername, gotPassword, ok := r.BasicAuth()
+		if !ok {
+			logger.LogError("Missing Azure DevOps webhook credentials", nil)
+			return false
+		}
+		if !hmac.Equal([]byte(gotUsername), []byte(username)) || !hmac.Equal([]byte(gotPassword), []byte(password)) {
+			logger.LogError("Invalid Azure DevOps webhook credentials", nil)
+			return false
+		}
 	}
-	logger.LogPRReview(prNumber, repo, "review posted")
 
-	// Send Slack notification
-	if err := slClient.SendPRReviewNotification(title, url, summary); err != nil {
-		logger.LogError("Failed to send Slack notification", err)
-		return
+	if secret != "" {
+		signature := r.Header.Get("X-Azure-DevOps-Secret")
+		if signature == "" {
+			logger.LogError("Missing Azure DevOps webhook secret", nil)
+			return false
+		}
+		if !hmac.Equal([]byte(signature), []byte(secret)) {
+			logger.LogError("Invalid Azure DevOps webhook secret", nil)
+			return false
+		}
 	}
-	logger.LogSlackNotification(os.Getenv("SLACK_CHANNEL_ID"), "PR review summary")
 
-	logger.LogPRReview(prNumber, repo, "completed")
-} 
\ No newline at end of file
+	return true
+}
+
+func verifyGitLabWebhook(r *http.Request, token string) bool {
+	if token == "" {
+		logger.LogDebug("No GitLab webhook token set, skipping verification")
+		return true
+	}
+
+	signature := r.Header.Get("X-GitLab-Token")
+	if signature == "" {
+		logger.LogError("Missing GitLab webhook token", nil)
+		return false
+	}
+
+	isValid := signature == token
+	if !isValid {
+		logger.LogError("Invalid GitLab webhook token", nil)
+	}
+	return isValid
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pr-agent-reviewer/vcs"
+)
+
+// sign returns the hex HMAC-SHA256 digest of body
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	const body = `{"action":"opened"}`
+
+	tests := []struct {
+		name     string
+		provider vcs.ProviderType
+		secret   string
+		headers  map[string]string
+		want     bool
+	}{
+		{name: "GitHub without a secret", provider: vcs.ProviderGitHub, want: true},
+		{name: "GitHub valid signature", provider: vcs.ProviderGitHub, secret: "s3cret", headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sign("s3cret", body)}, want: true},
+		{name: "GitHub missing signature", provider: vcs.ProviderGitHub, secret: "s3cret", want: false},
+		{name: "GitHub signed with another secret", provider: vcs.ProviderGitHub, secret: "s3cret", headers: map[string]string{"X-Hub-Signature-256": "sha256=" + sign("other", body)}, want: false},
+		{name: "GitHub signature without prefix", provider: vcs.ProviderGitHub, secret: "s3cret", headers: map[string]string{"X-Hub-Signature-256": sign("s3cret", body)}, want: false},
+		{name: "GitLab without a token", provider: vcs.ProviderGitLab, want: true},
+		{name: "GitLab matching token", provider: vcs.ProviderGitLab, secret: "t0ken", headers: map[string]string{"X-Gitlab-Token": "t0ken"}, want: true},
+		{name: "GitLab missing token", provider: vcs.ProviderGitLab, secret: "t0ken", want: false},
+		{name: "GitLab wrong token", provider: vcs.ProviderGitLab, secret: "t0ken", headers: map[string]string{"X-Gitlab-Token": "other"}, want: false},
+		{name: "Bitbucket without a secret", provider: vcs.ProviderBitbucket, want: true},
+		{name: "Bitbucket valid signature", provider: vcs.ProviderBitbucket, secret: "s3cret", headers: map[string]string{"X-Hub-Signature": "sha256=" + sign("s3cret", body)}, want: true},
+		{name: "Bitbucket missing signature", provider: vcs.ProviderBitbucket, secret: "s3cret", want: false},
+		{name: "Bitbucket signed with another secret", provider: vcs.ProviderBitbucket, secret: "s3cret", headers: map[string]string{"X-Hub-Signature": "sha256=" + sign("other", body)}, want: false},
+		{name: "Gitea without a secret", provider: vcs.ProviderGitea, want: true},
+		{name: "Gitea valid signature", provider: vcs.ProviderGitea, secret: "s3cret", headers: map[string]string{"X-Gitea-Signature": sign("s3cret", body)}, want: true},
+		{name: "Gitea missing signature", provider: vcs.ProviderGitea, secret: "s3cret", want: false},
+		{name: "Gitea signature with prefix", provider: vcs.ProviderGitea, secret: "s3cret", headers: map[string]string{"X-Gitea-Signature": "sha256=" + sign("s3cret", body)}, want: false},
+		{name: "Gitea signed with another secret", provider: vcs.ProviderGitea, secret: "s3cret", headers: map[string]string{"X-Gitea-Signature": sign("other", body)}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+
+			host := &vcs.Host{Name: string(tt.provider), Type: tt.provider, WebhookSecret: tt.secret}
+			if got := verifyWebhookSignature(r, host); got != tt.want {
+				t.Errorf("verifyWebhookSignature() = %t, want %t", got, tt.want)
+			}
+
+			// The handlers read the body after it has been verified
+			if got, err := io.ReadAll(r.Body); err != nil || string(got) != body {
+				t.Errorf("body after verification = %q, %v, want %q", got, err, body)
+			}
+		})
+	}
+}
+
+func TestVerifyAzureDevOpsWebhook(t *testing.T) {
+	type credentials struct {
+		username, password string
+	}
+
+	tests := []struct {
+		name      string
+		username  string
+		password  string
+		secret    string
+		basicAuth *credentials
+		header    string
+		want      bool
+	}{
+		{name: "nothing configured", want: true},
+		{name: "valid credentials", username: "hook", password: "pa55", basicAuth: &credentials{"hook", "pa55"}, want: true},
+		{name: "missing credentials", username: "hook", password: "pa55", want: false},
+		{name: "wrong password", username: "hook", password: "pa55", basicAuth: &credentials{"hook", "other"}, want: false},
+		{name: "wrong username", username: "hook", password: "pa55", basicAuth: &credentials{"other", "pa55"}, want: false},
+		{name: "valid secret", secret: "s3cret", header: "s3cret", want: true},
+		{name: "missing secret", secret: "s3cret", want: false},
+		{name: "wrong secret", secret: "s3cret", header: "other", want: false},
+		{name: "valid credentials and secret", username: "hook", password: "pa55", secret: "s3cret", basicAuth: &credentials{"hook", "pa55"}, header: "s3cret", want: true},
+		{name: "valid credentials and wrong secret", username: "hook", password: "pa55", secret: "s3cret", basicAuth: &credentials{"hook", "pa55"}, header: "other", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AZURE_DEVOPS_WEBHOOK_USERNAME", tt.username)
+			t.Setenv("AZURE_DEVOPS_WEBHOOK_PASSWORD", tt.password)
+
+			r := httptest.NewRequest("POST", "/webhook", strings.NewReader(`{"eventType":"git.pullrequest.created"}`))
+			if tt.basicAuth != nil {
+				r.SetBasicAuth(tt.basicAuth.username, tt.basicAuth.password)
+			}
+			if tt.header != "" {
+				r.Header.Set("X-Azure-DevOps-Secret", tt.header)
+			}
+
+			host := &vcs.Host{Name: string(vcs.ProviderAzureDevOps), Type: vcs.ProviderAzureDevOps, WebhookSecret: tt.secret}
+			if got := verifyWebhookSignature(r, host); got != tt.want {
+				t.Errorf("verifyWebhookSignature() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
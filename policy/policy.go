@@ -0,0 +1,206 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/types"
+)
+
+// PullRequest holds the attributes of a pull/merge request the policy
+// decides on
+type PullRequest struct {
+	Draft        bool
+	Labels       []string
+	Title        string
+	TargetBranch string
+	Author       string
+	// DiffLines is the number of added and deleted lines, or -1 when the
+	// webhook payload does not carry it
+	DiffLines int
+}
+
+// Policy decides which pull/merge requests are reviewed
+type Policy struct {
+	skipDrafts     bool
+	skipLabels     []string
+	requireLabels  []string
+	skipTitles     []string
+	targetBranches []string
+	allowAuthors   []string
+	denyAuthors    []string
+	minDiffLines   int
+	maxDiffLines   int
+
+	// Verdict rules, see Verdict
+	requestChangesOn   []string
+	approveMaxSeverity string
+}
+
+// NewPolicy creates a new policy based on the configuration
+func NewPolicy() *Policy {
+	p := &Policy{
+		skipDrafts:     os.Getenv("REVIEW_SKIP_DRAFTS") != "false",
+		skipLabels:     envList("REVIEW_SKIP_LABELS", "skip-ai-review"),
+		requireLabels:  envList("REVIEW_REQUIRE_LABELS", ""),
+		skipTitles:     envList("REVIEW_SKIP_TITLE_PREFIXES", "WIP,[WIP],Draft:"),
+		targetBranches: envList("REVIEW_TARGET_BRANCHES", ""),
+		allowAuthors:   envList("REVIEW_ALLOW_AUTHORS", ""),
+		denyAuthors:    envList("REVIEW_DENY_AUTHORS", ""),
+		minDiffLines:   envInt("REVIEW_MIN_DIFF_LINES"),
+		maxDiffLines:   envInt("REVIEW_MAX_DIFF_LINES"),
+
+		requestChangesOn:   requestChangesOn(envList("REVIEW_REQUEST_CHANGES_ON", "critical,security")),
+		approveMaxSeverity: approveMaxSeverity(os.Getenv("REVIEW_APPROVE_MAX_SEVERITY")),
+	}
+
+	logger.LogInfo("Initialized review policy (skip drafts: %t, skip labels: %v, require labels: %v, target branches: %v, request changes on: %v, approve up to: %s)",
+		p.skipDrafts, p.skipLabels, p.requireLabels, p.targetBranches, p.requestChangesOn, p.approveMaxSeverity)
+	return p
+}
+
+// Evaluate reports whether a pull/merge request should be reviewed, and the
+// reason when it should not
+func (p *Policy) Evaluate(pr PullRequest) (bool, string) {
+	if p.skipDrafts && pr.Draft {
+		return false, "draft"
+	}
+
+	for _, label := range pr.Labels {
+		if containsFold(p.skipLabels, label) {
+			return false, fmt.Sprintf("labeled %s", label)
+		}
+	}
+	if len(p.requireLabels) > 0 && !hasAnyLabel(pr.Labels, p.requireLabels) {
+		return false, fmt.Sprintf("missing one of the labels %v", p.requireLabels)
+	}
+
+	title := strings.ToLower(strings.TrimSpace(pr.Title))
+	for _, prefix := range p.skipTitles {
+		if strings.HasPrefix(title, strings.ToLower(prefix)) {
+			return false, fmt.Sprintf("title starts with %s", prefix)
+		}
+	}
+
+	if len(p.targetBranches) > 0 && !matchesAny(p.targetBranches, pr.TargetBranch) {
+		return false, fmt.Sprintf("target branch %s not in %v", pr.TargetBranch, p.targetBranches)
+	}
+
+	if containsFold(p.denyAuthors, pr.Author) {
+		return false, fmt.Sprintf("author %s is denied", pr.Author)
+	}
+	if len(p.allowAuthors) > 0 && !containsFold(p.allowAuthors, pr.Author) {
+		return false, fmt.Sprintf("author %s is not allowed", pr.Author)
+	}
+
+	if pr.DiffLines >= 0 {
+		return p.EvaluateSize(pr.DiffLines)
+	}
+	return true, ""
+}
+
+// EvaluateSize reports whether a diff of the given number of changed lines
+// is within the configured size limits, and the reason when it is not
+func (p *Policy) EvaluateSize(lines int) (bool, string) {
+	if p.minDiffLines > 0 && lines < p.minDiffLines {
+		return false, fmt.Sprintf("diff of %d lines is below the minimum of %d", lines, p.minDiffLines)
+	}
+	if p.maxDiffLines > 0 && lines > p.maxDiffLines {
+		return false, fmt.Sprintf("diff of %d lines is above the maximum of %d", lines, p.maxDiffLines)
+	}
+	return true, ""
+}
+
+// HasSizeLimits reports whether a minimum or maximum diff size is configured
+func (p *Policy) HasSizeLimits() bool {
+	return p.minDiffLines > 0 || p.maxDiffLines > 0
+}
+
+// IsTriggerLabel reports whether adding or removing a label can change the
+// policy's decision
+func (p *Policy) IsTriggerLabel(label string) bool {
+	return containsFold(p.skipLabels, label) || containsFold(p.requireLabels, label)
+}
+
+func hasAnyLabel(labels, wanted []string) bool {
+	for _, label := range labels {
+		if containsFold(wanted, label) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether a branch matches one of the glob patterns, e.g.
+// main or release/*
+func matchesAny(patterns []string, branch string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, branch); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// envList reads a comma-separated list, falling back to the default when the
+// variable is empty
+func envList(key, fallback string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		value = fallback
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func envInt(key string) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value
+}
+
+// ChangedLabels returns the labels added or removed between two label sets
+func ChangedLabels(previous, current []string) []string {
+	var changed []string
+	for _, label := range previous {
+		if !containsFold(current, label) {
+			changed = append(changed, label)
+		}
+	}
+	for _, label := range current {
+		if !containsFold(previous, label) {
+			changed = append(changed, label)
+		}
+	}
+	return changed
+}
+
+// CountDiffLines counts the added and deleted lines in a list of changes
+func CountDiffLines(changes []types.FileChange) int {
+	lines := 0
+	for _, change := range changes {
+		lines += change.Additions + change.Deletions
+	}
+	return lines
+}
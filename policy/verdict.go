@@ -0,0 +1,118 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"pr-agent-reviewer/logger"
+
+	"pr-agent-reviewer/types"
+)
+
+// severityRank orders severities from least to most severe
+var severityRank = map[string]int{
+	types.SeverityNit:      1,
+	types.SeverityMinor:    2,
+	types.SeverityMajor:    3,
+	types.SeverityCritical: 4,
+}
+
+// categories are the finding categories the review prompt asks for
+var categories = map[string]bool{
+	types.CategorySecurity: true,
+	"bug":                  true,
+	"performance":          true,
+	"maintainability":      true,
+	"style":                true,
+}
+
+// approveMaxSeverity validates REVIEW_APPROVE_MAX_SEVERITY. An unknown
+// value disables approval rather than approving up to minor findings.
+func approveMaxSeverity(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return "never"
+	}
+	if _, ok := severityRank[value]; ok || value == "none" || value == "never" {
+		return value
+	}
+	logger.LogError(fmt.Sprintf("Invalid REVIEW_APPROVE_MAX_SEVERITY %q, approval is disabled", value), nil)
+	return "never"
+}
+
+// requestChangesOn validates the entries of REVIEW_REQUEST_CHANGES_ON and
+// drops the ones that are neither a severity nor a category
+func requestChangesOn(values []string) []string {
+	var triggers []string
+	for _, value := range values {
+		value = strings.ToLower(value)
+		if _, ok := severityRank[value]; !ok && !categories[value] {
+			logger.LogError(fmt.Sprintf("Ignoring unknown severity or category %q in REVIEW_REQUEST_CHANGES_ON", value), nil)
+			continue
+		}
+		triggers = append(triggers, value)
+	}
+	return triggers
+}
+
+// rank returns the rank of a finding's severity. Findings without a known
+// severity rank as minor.
+func rank(severity string) int {
+	if r, ok := severityRank[severity]; ok {
+		return r
+	}
+	return severityRank[types.SeverityMinor]
+}
+
+// Verdict decides what a review submits from the severities of its
+// findings. It requests changes when a finding is at or above a severity
+// listed in REVIEW_REQUEST_CHANGES_ON, or in a category listed there, and
+// approves when no finding is above REVIEW_APPROVE_MAX_SEVERITY ("none"
+// approves only reviews without findings, "never", the default, disables
+// approval).
+// Anything in between, and reviews whose findings are not known, only
+// comment.
+func (p *Policy) Verdict(review *types.Review) types.Verdict {
+	if !review.Structured {
+		return types.VerdictComment
+	}
+
+	highest := 0
+	for _, finding := range review.Findings {
+		if p.Blocking(finding) {
+			return types.VerdictRequestChanges
+		}
+		if r := rank(finding.Severity); r > highest {
+			highest = r
+		}
+	}
+
+	switch p.approveMaxSeverity {
+	case "never":
+		return types.VerdictComment
+	case "none":
+		if highest > 0 {
+			return types.VerdictComment
+		}
+	default:
+		if highest > rank(p.approveMaxSeverity) {
+			return types.VerdictComment
+		}
+	}
+	return types.VerdictApprove
+}
+
+// Blocking reports whether a finding requests changes: it is at or above a
+// severity listed in REVIEW_REQUEST_CHANGES_ON, or in a category listed there
+func (p *Policy) Blocking(finding types.Finding) bool {
+	for _, trigger := range p.requestChangesOn {
+		trigger = strings.ToLower(trigger)
+		if r, isSeverity := severityRank[trigger]; isSeverity && rank(finding.Severity) >= r {
+			return true
+		}
+		if trigger == finding.Category {
+			return true
+		}
+	}
+	return false
+}
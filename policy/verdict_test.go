@@ -0,0 +1,151 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"pr-agent-reviewer/types"
+)
+
+func TestBlocking(t *testing.T) {
+	p := &Policy{requestChangesOn: []string{"Major", "security"}}
+
+	tests := []struct {
+		name    string
+		finding types.Finding
+		want    bool
+	}{
+		{name: "at the severity", finding: types.Finding{Severity: types.SeverityMajor}, want: true},
+		{name: "above the severity", finding: types.Finding{Severity: types.SeverityCritical}, want: true},
+		{name: "below the severity", finding: types.Finding{Severity: types.SeverityMinor}, want: false},
+		{name: "unknown severity ranks as minor", finding: types.Finding{Severity: "weird"}, want: false},
+		{name: "listed category", finding: types.Finding{Severity: types.SeverityNit, Category: "security"}, want: true},
+		{name: "other category", finding: types.Finding{Severity: types.SeverityNit, Category: "style"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Blocking(tt.finding); got != tt.want {
+				t.Errorf("Blocking(%+v) = %t, want %t", tt.finding, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	nit := types.Finding{Severity: types.SeverityNit}
+	minor := types.Finding{Severity: types.SeverityMinor}
+	critical := types.Finding{Severity: types.SeverityCritical}
+	security := types.Finding{Severity: types.SeverityNit, Category: "security"}
+
+	tests := []struct {
+		name       string
+		approveMax string
+		review     *types.Review
+		want       types.Verdict
+	}{
+		{
+			name:       "unstructured review",
+			approveMax: "none",
+			review:     &types.Review{},
+			want:       types.VerdictComment,
+		},
+		{
+			name:       "blocking severity",
+			approveMax: "minor",
+			review:     &types.Review{Structured: true, Findings: []types.Finding{nit, critical}},
+			want:       types.VerdictRequestChanges,
+		},
+		{
+			name:       "blocking category",
+			approveMax: "minor",
+			review:     &types.Review{Structured: true, Findings: []types.Finding{security}},
+			want:       types.VerdictRequestChanges,
+		},
+		{
+			name:       "never approves",
+			approveMax: "never",
+			review:     &types.Review{Structured: true},
+			want:       types.VerdictComment,
+		},
+		{
+			name:       "none approves a clean review",
+			approveMax: "none",
+			review:     &types.Review{Structured: true},
+			want:       types.VerdictApprove,
+		},
+		{
+			name:       "none comments on any finding",
+			approveMax: "none",
+			review:     &types.Review{Structured: true, Findings: []types.Finding{nit}},
+			want:       types.VerdictComment,
+		},
+		{
+			name:       "at the approval severity",
+			approveMax: "minor",
+			review:     &types.Review{Structured: true, Findings: []types.Finding{nit, minor}},
+			want:       types.VerdictApprove,
+		},
+		{
+			name:       "above the approval severity",
+			approveMax: "nit",
+			review:     &types.Review{Structured: true, Findings: []types.Finding{minor}},
+			want:       types.VerdictComment,
+		},
+		{
+			name:       "unknown severity ranks as minor",
+			approveMax: "nit",
+			review:     &types.Review{Structured: true, Findings: []types.Finding{{Severity: "weird"}}},
+			want:       types.VerdictComment,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{requestChangesOn: []string{"Critical", "security"}, approveMaxSeverity: tt.approveMax}
+			if got := p.Verdict(tt.review); got != tt.want {
+				t.Errorf("Verdict() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApprovalIsOptIn(t *testing.T) {
+	t.Setenv("REVIEW_APPROVE_MAX_SEVERITY", "")
+
+	p := NewPolicy()
+	if got := p.Verdict(&types.Review{Structured: true}); got != types.VerdictComment {
+		t.Errorf("Verdict() with the default policy = %s, want %s", got, types.VerdictComment)
+	}
+}
+
+func TestNewPolicyValidatesVerdictSettings(t *testing.T) {
+	tests := []struct {
+		name                   string
+		requestChangesOn       string
+		approveMaxSeverity     string
+		wantRequestChangesOn   []string
+		wantApproveMaxSeverity string
+	}{
+		{name: "defaults", wantRequestChangesOn: []string{"critical", "security"}, wantApproveMaxSeverity: "never"},
+		{name: "known values", requestChangesOn: "Major, bug", approveMaxSeverity: " Nit ", wantRequestChangesOn: []string{"major", "bug"}, wantApproveMaxSeverity: "nit"},
+		{name: "none", approveMaxSeverity: "none", wantRequestChangesOn: []string{"critical", "security"}, wantApproveMaxSeverity: "none"},
+		{name: "misspelled approval severity", approveMaxSeverity: "critcal", wantRequestChangesOn: []string{"critical", "security"}, wantApproveMaxSeverity: "never"},
+		{name: "misspelled request changes entry", requestChangesOn: "critcal,security", wantRequestChangesOn: []string{"security"}, wantApproveMaxSeverity: "never"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REVIEW_REQUEST_CHANGES_ON", tt.requestChangesOn)
+			t.Setenv("REVIEW_APPROVE_MAX_SEVERITY", tt.approveMaxSeverity)
+
+			p := NewPolicy()
+			if !reflect.DeepEqual(p.requestChangesOn, tt.wantRequestChangesOn) {
+				t.Errorf("requestChangesOn = %q, want %q", p.requestChangesOn, tt.wantRequestChangesOn)
+			}
+			if p.approveMaxSeverity != tt.wantApproveMaxSeverity {
+				t.Errorf("approveMaxSeverity = %q, want %q", p.approveMaxSeverity, tt.wantApproveMaxSeverity)
+			}
+		})
+	}
+}
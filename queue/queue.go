@@ -0,0 +1,461 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/types"
+)
+
+// Job represents a single pull/merge request review waiting in the queue
+type Job struct {
+	ID       string `json:"id"`
+	Provider string `json:"provider"`
+	PRNumber int    `json:"pr_number"`
+	Repo     string `json:"repo"`
+	HeadSHA  string `json:"head_sha"`
+	// BaseSHA is the previously reviewed head when the review is incremental
+	BaseSHA    string    `json:"base_sha,omitempty"`
+	Title      string    `json:"title"`
+	URL        string    `json:"url"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	// Forced is set for reviews requested explicitly, which bypass the
+	// review policy
+	Forced bool `json:"forced,omitempty"`
+	// InstallationID is the GitHub App installation the webhook was
+	// delivered for, used to authenticate as the app
+	InstallationID int64 `json:"installation_id,omitempty"`
+
+	// Set when the job was queued by a command in a PR/MR comment. The reply
+	// to the command is kept in Review and ReviewPosted.
+	Command  string `json:"command,omitempty"`
+	Args     string `json:"args,omitempty"`
+	ThreadID string `json:"thread_id,omitempty"`
+	Author   string `json:"author,omitempty"`
+
+	// Progress recorded after each completed stage so that a resumed or
+	// re-driven job does not repeat work that already succeeded. Findings
+	// are the review's inline comments, anchored to lines of the diff, and
+	// Verdict is what the review submits.
+	Review       string          `json:"review,omitempty"`
+	Findings     []types.Finding `json:"findings,omitempty"`
+	Verdict      types.Verdict   `json:"verdict,omitempty"`
+	Summary      string          `json:"summary,omitempty"`
+	ReviewPosted bool            `json:"review_posted,omitempty"`
+	// CheckID is the check reporting the review while it is running, kept
+	// so that a job resumed after a restart completes the same check
+	CheckID string `json:"check_id,omitempty"`
+
+	// Set when the job is moved to the dead-letter store
+	LastError string    `json:"last_error,omitempty"`
+	FailedAt  time.Time `json:"failed_at,omitempty"`
+}
+
+// repoKey identifies the repository of a job across providers
+func (j *Job) repoKey() string {
+	return j.Provider + ":" + j.Repo
+}
+
+// ErrSuperseded is the cause of the cancellation of a job superseded by a
+// newer head SHA of the same PR
+var ErrSuperseded = errors.New("superseded by a newer commit")
+
+// Handler processes a job taken off the queue. The context is cancelled when
+// the job is superseded by a newer head SHA of the same PR, with
+// ErrSuperseded as its cause, or when the queue shuts down.
+type Handler func(ctx context.Context, job *Job) error
+
+// activeJob is a job currently being processed by a worker
+type activeJob struct {
+	job    *Job
+	cancel context.CancelCauseFunc
+}
+
+// Queue is a durable review queue served by a bounded pool of workers.
+// Every job is written to disk before it is accepted and only removed once a
+// worker has finished with it, so jobs left over from a previous run are
+// picked up again on startup. Jobs whose handler fails are moved to a
+// dead-letter directory where they can be listed and re-driven. Queuing a
+// newer head SHA of a PR drops or cancels the older jobs for that PR.
+type Queue struct {
+	dir        string
+	deadDir    string
+	workers    int
+	maxPerRepo int
+
+	// ctx is the parent of every job context; it is cancelled when Stop
+	// runs out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []*Job
+	running map[string]int
+	active  map[string]*activeJob
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewQueue creates a new queue based on the configuration and loads any jobs
+// persisted by a previous run
+func NewQueue() (*Queue, error) {
+	dir := os.Getenv("QUEUE_DIR")
+	if dir == "" {
+		dir = filepath.Join("data", "queue")
+	}
+
+	deadDir := filepath.Join(dir, "dead")
+	if err := os.MkdirAll(deadDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		ctx:        ctx,
+		cancel:     cancel,
+		dir:        dir,
+		deadDir:    deadDir,
+		workers:    envInt("QUEUE_WORKERS", 4),
+		maxPerRepo: envInt("QUEUE_MAX_PER_REPO", 1),
+		running:    make(map[string]int),
+		active:     make(map[string]*activeJob),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	logger.LogInfo("Initialized review queue in %s (workers: %d, max per repo: %d, pending: %d)",
+		dir, q.workers, q.maxPerRepo, len(q.pending))
+	return q, nil
+}
+
+// Enqueue persists a job and hands it to the worker pool
+func (q *Queue) Enqueue(job *Job) error {
+	if job.ID == "" {
+		job.ID = newJobID()
+	}
+	if job.EnqueuedAt.IsZero() {
+		job.EnqueuedAt = time.Now()
+	}
+
+	if err := writeJob(q.path(job.ID), job); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.supersede(job)
+	q.pending = append(q.pending, job)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+
+	logger.LogInfo("Queued job %s for PR #%d in %s", job.ID, job.PRNumber, job.Repo)
+	return nil
+}
+
+// supersede drops pending jobs and cancels running jobs of the same PR at a
+// different head SHA. Command jobs neither supersede nor are superseded. The
+// caller must hold the lock.
+func (q *Queue) supersede(job *Job) {
+	if job.HeadSHA == "" || job.Command != "" {
+		return
+	}
+
+	pending := q.pending[:0]
+	for _, other := range q.pending {
+		if !supersedes(job, other) {
+			pending = append(pending, other)
+			continue
+		}
+		if err := os.Remove(q.path(other.ID)); err != nil && !os.IsNotExist(err) {
+			logger.LogError("Failed to remove superseded job "+other.ID, err)
+		}
+		logger.LogInfo("Dropped job %s for PR #%d in %s: superseded by %s", other.ID, other.PRNumber, other.Repo, job.HeadSHA)
+	}
+	q.pending = pending
+
+	for _, active := range q.active {
+		if supersedes(job, active.job) {
+			active.cancel(ErrSuperseded)
+			logger.LogInfo("Cancelled job %s for PR #%d in %s: superseded by %s", active.job.ID, active.job.PRNumber, active.job.Repo, job.HeadSHA)
+		}
+	}
+}
+
+// supersedes reports whether newer replaces older
+func supersedes(newer, older *Job) bool {
+	return newer.Provider == older.Provider && newer.Repo == older.Repo && newer.PRNumber == older.PRNumber &&
+		older.Command == "" && older.HeadSHA != "" && older.HeadSHA != newer.HeadSHA
+}
+
+// Start launches the worker pool
+func (q *Queue) Start(handler Handler) {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker(handler)
+	}
+}
+
+func (q *Queue) worker(handler Handler) {
+	defer q.wg.Done()
+
+	for {
+		active, ctx := q.next()
+		if active == nil {
+			return
+		}
+		job := active.job
+
+		err := handler(ctx, job)
+		superseded := errors.Is(context.Cause(ctx), ErrSuperseded)
+		active.cancel(nil)
+
+		q.mu.Lock()
+		delete(q.active, job.ID)
+		q.mu.Unlock()
+
+		switch {
+		case err != nil && q.ctx.Err() != nil:
+			// Interrupted by shutdown: leave the job on disk so that it is
+			// resumed from its last checkpoint on the next start
+			logger.LogInfo("Job %s for PR #%d in %s interrupted by shutdown", job.ID, job.PRNumber, job.Repo)
+			q.release(job)
+			continue
+		case err == nil:
+		case superseded:
+			logger.LogInfo("Job %s for PR #%d in %s stopped: superseded by a newer commit", job.ID, job.PRNumber, job.Repo)
+		default:
+			logger.LogError(fmt.Sprintf("Job %s for PR #%d in %s failed", job.ID, job.PRNumber, job.Repo), err)
+			q.deadLetter(job, err)
+		}
+		q.finish(job)
+	}
+}
+
+// next blocks until a job is available whose repository is below its
+// concurrency cap, or until the queue is stopped. The job is registered as
+// active under the same lock that dequeues it, so that a newer commit
+// arriving meanwhile always finds it to supersede.
+func (q *Queue) next() (*activeJob, context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.stopped {
+			return nil, nil
+		}
+
+		for i, job := range q.pending {
+			if q.running[job.repoKey()] >= q.maxPerRepo {
+				continue
+			}
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.running[job.repoKey()]++
+
+			ctx, cancel := context.WithCancelCause(q.ctx)
+			active := &activeJob{job: job, cancel: cancel}
+			q.active[job.ID] = active
+			return active, ctx
+		}
+
+		q.cond.Wait()
+	}
+}
+
+// finish removes a processed job from disk and frees its repository slot
+func (q *Queue) finish(job *Job) {
+	if err := os.Remove(q.path(job.ID)); err != nil && !os.IsNotExist(err) {
+		logger.LogError("Failed to remove finished job "+job.ID, err)
+	}
+	q.release(job)
+}
+
+// release frees the repository slot held by a job
+func (q *Queue) release(job *Job) {
+	q.mu.Lock()
+	q.running[job.repoKey()]--
+	if q.running[job.repoKey()] <= 0 {
+		delete(q.running, job.repoKey())
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// Stop stops handing out jobs and waits for running ones to finish. If the
+// context expires first, running jobs are cancelled and left on disk to be
+// resumed on the next start, as are jobs that never left the queue.
+func (q *Queue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+	q.stopped = true
+	running := len(q.active)
+	pending := len(q.pending)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+
+	logger.LogInfo("Stopping review queue: waiting for %d running jobs (%d pending jobs kept for next start)", running, pending)
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logger.LogInfo("Review queue drained")
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Out of time: cancel running jobs and give workers a moment to
+	// record that they were interrupted
+	q.cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+	}
+	return fmt.Errorf("review queue did not drain in time: %w", ctx.Err())
+}
+
+// Checkpoint persists the progress of a running job
+func (q *Queue) Checkpoint(job *Job) error {
+	return writeJob(q.path(job.ID), job)
+}
+
+// deadLetter moves a failed job to the dead-letter directory
+func (q *Queue) deadLetter(job *Job, cause error) {
+	job.LastError = cause.Error()
+	job.FailedAt = time.Now()
+
+	if err := writeJob(filepath.Join(q.deadDir, job.ID+".json"), job); err != nil {
+		logger.LogError("Failed to dead-letter job "+job.ID, err)
+		return
+	}
+	logger.LogInfo("Moved job %s for PR #%d in %s to the dead-letter store", job.ID, job.PRNumber, job.Repo)
+}
+
+// DeadLetters lists the jobs in the dead-letter store, oldest first
+func (q *Queue) DeadLetters() ([]*Job, error) {
+	return readJobs(q.deadDir)
+}
+
+// Redrive moves a dead-lettered job back onto the queue. Stages the job
+// already completed are not repeated.
+func (q *Queue) Redrive(id string) (*Job, error) {
+	path := filepath.Join(q.deadDir, filepath.Base(id)+".json")
+	job, err := readJob(path)
+	if err != nil {
+		return nil, err
+	}
+
+	job.LastError = ""
+	job.FailedAt = time.Time{}
+	if err := q.Enqueue(job); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(path); err != nil {
+		logger.LogError("Failed to remove re-driven job "+id, err)
+	}
+	logger.LogInfo("Re-drove dead-lettered job %s for PR #%d in %s", job.ID, job.PRNumber, job.Repo)
+	return job, nil
+}
+
+// load reads every job persisted in the queue directory
+func (q *Queue) load() error {
+	jobs, err := readJobs(q.dir)
+	if err != nil {
+		return err
+	}
+	q.pending = jobs
+	return nil
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// readJobs reads every job file in a directory, oldest first
+func readJobs(dir string) ([]*Job, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue directory: %v", err)
+	}
+
+	var jobs []*Job
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		job, err := readJob(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			logger.LogError("Failed to load job "+entry.Name(), err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].EnqueuedAt.Before(jobs[j].EnqueuedAt)
+	})
+	return jobs, nil
+}
+
+func readJob(path string) (*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job: %w", err)
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %v", err)
+	}
+	return &job, nil
+}
+
+// writeJob atomically writes a job to disk
+func writeJob(path string, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write job: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to persist job: %v", err)
+	}
+	return nil
+}
+
+func newJobID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
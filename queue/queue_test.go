@@ -0,0 +1,397 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestQueue creates a queue in dir with the given pool size and
+// per-repo cap
+func newTestQueue(t *testing.T, dir string, workers, maxPerRepo int) *Queue {
+	t.Helper()
+	t.Setenv("QUEUE_DIR", dir)
+	t.Setenv("QUEUE_WORKERS", strconv.Itoa(workers))
+	t.Setenv("QUEUE_MAX_PER_REPO", strconv.Itoa(maxPerRepo))
+
+	q, err := NewQueue()
+	if err != nil {
+		t.Fatalf("NewQueue() error = %v", err)
+	}
+	return q
+}
+
+// stopQueue stops the queue and waits for the workers to exit
+func stopQueue(t *testing.T, q *Queue) {
+	t.Helper()
+	if err := q.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+}
+
+// isStopped reports whether the queue stopped handing out jobs
+func (q *Queue) isStopped() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stopped
+}
+
+// receive waits for the next value on ch or fails the test
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the queue")
+		panic("unreachable")
+	}
+}
+
+func TestQueueResumesPersistedJobs(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now()
+
+	q := newTestQueue(t, dir, 1, 1)
+	for _, job := range []*Job{
+		{ID: "third", PRNumber: 3, Repo: "org/repo", EnqueuedAt: start.Add(2 * time.Second)},
+		{ID: "first", PRNumber: 1, Repo: "org/repo", EnqueuedAt: start},
+		{ID: "second", PRNumber: 2, Repo: "org/repo", EnqueuedAt: start.Add(time.Second)},
+	} {
+		if err := q.Enqueue(job); err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", job.ID, err)
+		}
+	}
+
+	// A queue opened on the same directory picks the jobs up, oldest first
+	resumed := newTestQueue(t, dir, 1, 1)
+	handled := make(chan string, 3)
+	resumed.Start(func(ctx context.Context, job *Job) error {
+		handled <- job.ID
+		return nil
+	})
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, receive(t, handled))
+	}
+	stopQueue(t, resumed)
+
+	if want := []string{"first", "second", "third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("handled jobs = %v, want %v", got, want)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("finished jobs left on disk: %v", files)
+	}
+	if empty := newTestQueue(t, dir, 1, 1); len(empty.pending) != 0 {
+		t.Errorf("pending jobs after a restart = %d, want 0", len(empty.pending))
+	}
+}
+
+func TestQueueSkipsUnreadableJobs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a job"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := newTestQueue(t, dir, 1, 1)
+	if err := q.Enqueue(&Job{PRNumber: 1, Repo: "org/repo"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	if resumed := newTestQueue(t, dir, 1, 1); len(resumed.pending) != 1 {
+		t.Errorf("pending jobs = %d, want 1", len(resumed.pending))
+	}
+}
+
+func TestQueueCapsJobsPerRepo(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxPerRepo int
+	}{
+		{name: "one job per repo", maxPerRepo: 1},
+		{name: "two jobs per repo", maxPerRepo: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t, t.TempDir(), 4, tt.maxPerRepo)
+			// The same repository name on another provider is a different
+			// repository
+			for i, provider := range []string{"github", "github", "github", "gitlab"} {
+				if err := q.Enqueue(&Job{Provider: provider, PRNumber: i + 1, Repo: "org/repo"}); err != nil {
+					t.Fatalf("Enqueue() error = %v", err)
+				}
+			}
+
+			started := make(chan string, 4)
+			release := make(chan struct{})
+			q.Start(func(ctx context.Context, job *Job) error {
+				started <- job.repoKey()
+				<-release
+				return nil
+			})
+
+			counts := map[string]int{}
+			for i := 0; i <= tt.maxPerRepo; i++ {
+				counts[receive(t, started)]++
+			}
+			select {
+			case repo := <-started:
+				t.Errorf("job for %s started above the per-repo cap", repo)
+			case <-time.After(50 * time.Millisecond):
+			}
+			if want := map[string]int{"github:org/repo": tt.maxPerRepo, "gitlab:org/repo": 1}; !reflect.DeepEqual(counts, want) {
+				t.Errorf("running jobs = %v, want %v", counts, want)
+			}
+
+			close(release)
+			for i := tt.maxPerRepo; i < 3; i++ {
+				receive(t, started)
+			}
+			stopQueue(t, q)
+		})
+	}
+}
+
+func TestQueueDeadLettersFailedJobs(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantDead bool
+	}{
+		{name: "succeeded", err: nil, wantDead: false},
+		{name: "failed", err: errors.New("review failed"), wantDead: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q := newTestQueue(t, dir, 1, 1)
+			if err := q.Enqueue(&Job{ID: "job", PRNumber: 1, Repo: "org/repo"}); err != nil {
+				t.Fatalf("Enqueue() error = %v", err)
+			}
+
+			handled := make(chan string, 1)
+			q.Start(func(ctx context.Context, job *Job) error {
+				job.Review = "partial review"
+				if err := q.Checkpoint(job); err != nil {
+					t.Errorf("Checkpoint() error = %v", err)
+				}
+				handled <- job.ID
+				return tt.err
+			})
+			receive(t, handled)
+			stopQueue(t, q)
+
+			dead, err := q.DeadLetters()
+			if err != nil {
+				t.Fatalf("DeadLetters() error = %v", err)
+			}
+			if !tt.wantDead {
+				if len(dead) != 0 {
+					t.Errorf("DeadLetters() = %d jobs, want none", len(dead))
+				}
+				return
+			}
+
+			if len(dead) != 1 {
+				t.Fatalf("DeadLetters() = %d jobs, want 1", len(dead))
+			}
+			if dead[0].LastError != tt.err.Error() || dead[0].FailedAt.IsZero() || dead[0].Review != "partial review" {
+				t.Errorf("dead-lettered job = %+v, want its error, failure time and progress", dead[0])
+			}
+			if resumed := newTestQueue(t, dir, 1, 1); len(resumed.pending) != 0 {
+				t.Errorf("pending jobs = %d, want the failed job off the queue", len(resumed.pending))
+			}
+		})
+	}
+}
+
+func TestQueueRedrive(t *testing.T) {
+	dir := t.TempDir()
+	q := newTestQueue(t, dir, 1, 1)
+	q.deadLetter(&Job{ID: "job", PRNumber: 1, Repo: "org/repo", Review: "partial review"}, errors.New("review failed"))
+
+	if _, err := q.Redrive("missing"); err == nil {
+		t.Error("Redrive() of an unknown job succeeded")
+	}
+
+	job, err := q.Redrive("job")
+	if err != nil {
+		t.Fatalf("Redrive() error = %v", err)
+	}
+	if job.LastError != "" || !job.FailedAt.IsZero() {
+		t.Errorf("re-driven job = %+v, want its failure cleared", job)
+	}
+	if dead, err := q.DeadLetters(); err != nil || len(dead) != 0 {
+		t.Errorf("DeadLetters() = %d jobs, %v, want none", len(dead), err)
+	}
+
+	// The re-driven job survives a restart and keeps its progress
+	resumed := newTestQueue(t, dir, 1, 1)
+	handled := make(chan *Job, 1)
+	resumed.Start(func(ctx context.Context, job *Job) error {
+		handled <- job
+		return nil
+	})
+	got := receive(t, handled)
+	stopQueue(t, resumed)
+
+	if got.ID != "job" || got.Review != "partial review" {
+		t.Errorf("handled job = %+v, want the re-driven job with its progress", got)
+	}
+}
+
+func TestQueueSupersedesPendingJobs(t *testing.T) {
+	older := Job{ID: "older", Provider: "github", PRNumber: 1, Repo: "org/repo", HeadSHA: "abc123"}
+
+	tests := []struct {
+		name     string
+		newer    Job
+		wantKept bool
+	}{
+		{name: "newer head of the same PR", newer: Job{Provider: "github", PRNumber: 1, Repo: "org/repo", HeadSHA: "def456"}, wantKept: false},
+		{name: "same head of the same PR", newer: Job{Provider: "github", PRNumber: 1, Repo: "org/repo", HeadSHA: "abc123"}, wantKept: true},
+		{name: "other PR", newer: Job{Provider: "github", PRNumber: 2, Repo: "org/repo", HeadSHA: "def456"}, wantKept: true},
+		{name: "other repo", newer: Job{Provider: "github", PRNumber: 1, Repo: "org/other", HeadSHA: "def456"}, wantKept: true},
+		{name: "same PR on another provider", newer: Job{Provider: "gitlab", PRNumber: 1, Repo: "org/repo", HeadSHA: "def456"}, wantKept: true},
+		{name: "no head SHA", newer: Job{Provider: "github", PRNumber: 1, Repo: "org/repo"}, wantKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q := newTestQueue(t, dir, 1, 1)
+			job, newer := older, tt.newer
+			if err := q.Enqueue(&job); err != nil {
+				t.Fatalf("Enqueue() error = %v", err)
+			}
+			if err := q.Enqueue(&newer); err != nil {
+				t.Fatalf("Enqueue() error = %v", err)
+			}
+
+			if kept := len(q.pending) == 2; kept != tt.wantKept {
+				t.Errorf("older job kept = %t, want %t", kept, tt.wantKept)
+			}
+			_, err := os.Stat(filepath.Join(dir, "older.json"))
+			if onDisk := err == nil; onDisk != tt.wantKept {
+				t.Errorf("older job on disk = %t, want %t", onDisk, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestQueueCancelsSupersededRunningJob(t *testing.T) {
+	q := newTestQueue(t, t.TempDir(), 2, 2)
+
+	started := make(chan string, 2)
+	stopped := make(chan error, 1)
+	q.Start(func(ctx context.Context, job *Job) error {
+		started <- job.HeadSHA
+		if job.HeadSHA != "abc123" {
+			return nil
+		}
+		<-ctx.Done()
+		stopped <- context.Cause(ctx)
+		return ctx.Err()
+	})
+
+	if err := q.Enqueue(&Job{PRNumber: 1, Repo: "org/repo", HeadSHA: "abc123"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	receive(t, started)
+	if err := q.Enqueue(&Job{PRNumber: 1, Repo: "org/repo", HeadSHA: "def456"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	if cause := receive(t, stopped); !errors.Is(cause, ErrSuperseded) {
+		t.Errorf("superseded job cancelled by %v, want %v", cause, ErrSuperseded)
+	}
+	if got := receive(t, started); got != "def456" {
+		t.Errorf("next job head = %q, want %q", got, "def456")
+	}
+	stopQueue(t, q)
+
+	if dead, err := q.DeadLetters(); err != nil || len(dead) != 0 {
+		t.Errorf("DeadLetters() = %d jobs, %v, want the superseded job not dead-lettered", len(dead), err)
+	}
+}
+
+func TestQueueStop(t *testing.T) {
+	tests := []struct {
+		name        string
+		expired     bool
+		wantErr     bool
+		wantResumed int
+	}{
+		{name: "drains running jobs", expired: false, wantErr: false, wantResumed: 1},
+		{name: "interrupts running jobs when out of time", expired: true, wantErr: true, wantResumed: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q := newTestQueue(t, dir, 1, 1)
+
+			started := make(chan struct{}, 1)
+			release := make(chan struct{})
+			q.Start(func(ctx context.Context, job *Job) error {
+				started <- struct{}{}
+				select {
+				case <-release:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+
+			if err := q.Enqueue(&Job{ID: "running", PRNumber: 1, Repo: "org/repo"}); err != nil {
+				t.Fatalf("Enqueue() error = %v", err)
+			}
+			receive(t, started)
+			if err := q.Enqueue(&Job{ID: "pending", PRNumber: 2, Repo: "org/repo"}); err != nil {
+				t.Fatalf("Enqueue() error = %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.expired {
+				cancel()
+			}
+			defer cancel()
+
+			stopped := make(chan error, 1)
+			go func() { stopped <- q.Stop(ctx) }()
+			if !tt.expired {
+				// Let the running job finish only once no new job can start
+				for !q.isStopped() {
+					time.Sleep(time.Millisecond)
+				}
+				close(release)
+			}
+
+			if err := receive(t, stopped); (err != nil) != tt.wantErr {
+				t.Errorf("Stop() error = %v, want error %t", err, tt.wantErr)
+			}
+			if dead, err := q.DeadLetters(); err != nil || len(dead) != 0 {
+				t.Errorf("DeadLetters() = %d jobs, %v, want none", len(dead), err)
+			}
+			if resumed := newTestQueue(t, dir, 1, 1); len(resumed.pending) != tt.wantResumed {
+				t.Errorf("jobs resumed on the next start = %d, want %d", len(resumed.pending), tt.wantResumed)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"pr-agent-reviewer/logger"
+
+	gh "github.com/google/go-github/v57/github"
+	"github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+	"github.com/xanzy/go-gitlab"
+)
+
+// permanentError marks an error that must not be retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error so that Do gives up on it immediately
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Do runs fn until it succeeds, returns a permanent error, runs out of
+// attempts or the context is cancelled. Attempts are spaced with exponential
+// backoff and jitter.
+func Do(ctx context.Context, stage string, fn func() error) error {
+	maxAttempts := envInt("RETRY_MAX_ATTEMPTS", 5)
+	baseDelay := envDuration("RETRY_BASE_DELAY", 2*time.Second)
+	maxDelay := envDuration("RETRY_MAX_DELAY", time.Minute)
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s cancelled: %w", stage, ctx.Err())
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s cancelled: %w", stage, ctx.Err())
+		}
+		if !IsTransient(err) {
+			return fmt.Errorf("%s failed permanently: %w", stage, err)
+		}
+		if attempt == maxAttempts {
+			break
+		}
+
+		delay := Backoff(attempt, baseDelay, maxDelay)
+		logger.LogInfo("Stage %s failed (attempt %d/%d), retrying in %v: %v", stage, attempt, maxAttempts, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s cancelled: %w", stage, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+
+	return fmt.Errorf("%s failed after %d attempts: %w", stage, maxAttempts, err)
+}
+
+// Backoff returns the delay before the next attempt: the base delay doubled
+// for every previous attempt, capped at max, with up to half of it jittered
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base << (attempt - 1)
+	if delay <= 0 || delay > max {
+		delay = max
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// IsTransient reports whether an error is worth retrying. Rate limits, 5xx
+// responses, timeouts and connection failures are transient; everything else,
+// including errors it does not recognise, is not.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var ghRateLimit *gh.RateLimitError
+	var ghAbuseLimit *gh.AbuseRateLimitError
+	var slackRateLimit *slack.RateLimitedError
+	if errors.As(err, &ghRateLimit) || errors.As(err, &ghAbuseLimit) || errors.As(err, &slackRateLimit) {
+		return true
+	}
+
+	if status, ok := statusCode(err); ok {
+		return status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+	}
+
+	var slackErr slack.SlackErrorResponse
+	if errors.As(err, &slackErr) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	// Decode failures, bad configuration and the like fail the same way
+	// on every attempt
+	return false
+}
+
+// statusCode extracts the HTTP status code carried by a client error
+func statusCode(err error) (int, bool) {
+	var ghErr *gh.ErrorResponse
+	if errors.As(err, &ghErr) && ghErr.Response != nil {
+		return ghErr.Response.StatusCode, true
+	}
+
+	var glErr *gitlab.ErrorResponse
+	if errors.As(err, &glErr) && glErr.Response != nil {
+		return glErr.Response.StatusCode, true
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode > 0 {
+		return apiErr.HTTPStatusCode, true
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode > 0 {
+		return reqErr.HTTPStatusCode, true
+	}
+
+	var coder interface{ HTTPStatusCode() int }
+	if errors.As(err, &coder) {
+		return coder.HTTPStatusCode(), true
+	}
+
+	return 0, false
+}
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,174 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	gh "github.com/google/go-github/v57/github"
+	"github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+	"github.com/xanzy/go-gitlab"
+
+	"pr-agent-reviewer/httpclient"
+)
+
+// statusError is an error carrying an HTTP status code
+type statusError int
+
+func (e statusError) Error() string       { return fmt.Sprintf("status %d", int(e)) }
+func (e statusError) HTTPStatusCode() int { return int(e) }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil, want: false},
+		{name: "permanent", err: Permanent(statusError(http.StatusBadGateway)), want: false},
+		{name: "GitHub rate limit", err: &gh.RateLimitError{}, want: true},
+		{name: "GitHub secondary rate limit", err: &gh.AbuseRateLimitError{}, want: true},
+		{name: "Slack rate limit", err: &slack.RateLimitedError{}, want: true},
+		{name: "GitHub server error", err: &gh.ErrorResponse{Response: &http.Response{StatusCode: http.StatusBadGateway}}, want: true},
+		{name: "GitHub not found", err: &gh.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}, want: false},
+		{name: "GitLab server error", err: &gitlab.ErrorResponse{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}}, want: true},
+		{name: "GitLab unauthorized", err: &gitlab.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnauthorized}}, want: false},
+		{name: "OpenAI too many requests", err: &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "OpenAI bad request", err: &openai.APIError{HTTPStatusCode: http.StatusBadRequest}, want: false},
+		{name: "OpenAI request error", err: &openai.RequestError{HTTPStatusCode: http.StatusInternalServerError, Err: io.EOF}, want: true},
+		{name: "status code request timeout", err: statusError(http.StatusRequestTimeout), want: true},
+		{name: "status code unprocessable", err: statusError(http.StatusUnprocessableEntity), want: false},
+		{name: "provider API server error", err: &httpclient.StatusError{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "provider API not found", err: &httpclient.StatusError{StatusCode: http.StatusNotFound}, want: false},
+		{name: "wrapped status code", err: fmt.Errorf("failed to post review: %w", statusError(http.StatusInternalServerError)), want: true},
+		{name: "Slack API error", err: slack.SlackErrorResponse{Err: "channel_not_found"}, want: false},
+		{name: "network error", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, want: true},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: true},
+		{name: "unexpected EOF", err: fmt.Errorf("failed to read response: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "cancelled", err: fmt.Errorf("failed to get changes: %w", context.Canceled), want: false},
+		{name: "connection reset", err: fmt.Errorf("failed to post review: %w", syscall.ECONNRESET), want: true},
+		{name: "unrecognised error", err: errors.New("malformed review"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		base    time.Duration
+		max     time.Duration
+		want    time.Duration
+	}{
+		{name: "first attempt", attempt: 1, base: time.Second, max: time.Minute, want: time.Second},
+		{name: "doubles per attempt", attempt: 3, base: time.Second, max: time.Minute, want: 4 * time.Second},
+		{name: "capped at max", attempt: 10, base: time.Second, max: time.Minute, want: time.Minute},
+		{name: "overflow capped at max", attempt: 80, base: time.Second, max: time.Minute, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := Backoff(tt.attempt, tt.base, tt.max); got < tt.want/2 || got > tt.want {
+					t.Fatalf("Backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	t.Setenv("RETRY_MAX_ATTEMPTS", "3")
+	t.Setenv("RETRY_BASE_DELAY", "1ms")
+	t.Setenv("RETRY_MAX_DELAY", "1ms")
+
+	transient := statusError(http.StatusServiceUnavailable)
+	permanent := statusError(http.StatusNotFound)
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "succeeds first time", errs: nil, wantCalls: 1},
+		{name: "succeeds after transient errors", errs: []error{transient, transient}, wantCalls: 3},
+		{name: "gives up on a permanent error", errs: []error{permanent}, wantCalls: 1, wantErr: permanent},
+		{name: "runs out of attempts", errs: []error{transient, transient, transient, transient}, wantCalls: 3, wantErr: transient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := Do(context.Background(), "stage", func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Do() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDoStopsWhenCancelled(t *testing.T) {
+	t.Setenv("RETRY_MAX_ATTEMPTS", "5")
+	t.Setenv("RETRY_BASE_DELAY", "1h")
+
+	tests := []struct {
+		name      string
+		cancel    bool
+		wantCalls int
+	}{
+		{name: "cancelled before the first attempt", cancel: true, wantCalls: 0},
+		{name: "cancelled while waiting to retry", cancel: false, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+
+			calls := 0
+			err := Do(ctx, "stage", func() error {
+				calls++
+				time.AfterFunc(10*time.Millisecond, cancel)
+				return statusError(http.StatusServiceUnavailable)
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Do() error = %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"pr-agent-reviewer/ai"
+	diffutil "pr-agent-reviewer/diff"
+	"pr-agent-reviewer/github"
+	"pr-agent-reviewer/logger"
+	"pr-agent-reviewer/policy"
+	"pr-agent-reviewer/queue"
+	"pr-agent-reviewer/retry"
+	"pr-agent-reviewer/store"
+	"pr-agent-reviewer/types"
+	"pr-agent-reviewer/vcs"
+)
+
+// errReviewSkipped is returned by processPR when the review policy rules the
+// changes out
+var errReviewSkipped = errors.New("review skipped by policy")
+
+// runJob processes a queued review or command and records the outcome in the
+// dedup store and the review history. Reviews cancelled because a newer commit arrived
+// are not abandoned, so a redelivery for the superseded head does not start
+// them again.
+func runJob(ctx context.Context, job *queue.Job) error {
+	run := &store.Run{
+		ID:        fmt.Sprintf("%s-%d", job.ID, time.Now().UnixNano()),
+		JobID:     job.ID,
+		Provider:  job.Provider,
+		Repo:      job.Repo,
+		PRNumber:  job.PRNumber,
+		HeadSHA:   job.HeadSHA,
+		Status:    store.RunRunning,
+		StartedAt: time.Now(),
+	}
+	saveRun(ctx, run)
+
+	ctx, usage := ai.WithUsage(ctx)
+	ctx = github.WithInstallation(ctx, job.InstallationID)
+	var err error
+	if job.Command != "" {
+		err = runCommand(ctx, job, run)
+	} else {
+		err = processPR(ctx, job, run)
+	}
+
+	run.BaseSHA = job.BaseSHA
+	run.Review = job.Review
+	run.Summary = job.Summary
+	run.Findings = runFindings(job.Findings)
+	run.ReviewPosted = job.ReviewPosted
+	run.PromptTokens, run.CompletionTokens = usage.Tokens()
+	run.FinishedAt = time.Now()
+	switch {
+	case errors.Is(err, errReviewSkipped):
+		run.Status = store.RunSkipped
+	case err == nil:
+		run.Status = store.RunCompleted
+	case ctx.Err() != nil:
+		run.Status = store.RunCancelled
+	default:
+		run.Status = store.RunFailed
+		run.Error = err.Error()
+	}
+	saveRun(ctx, run)
+
+	// Skipped reviews are abandoned so that a later change to the PR can
+	// trigger them again
+	if errors.Is(err, errReviewSkipped) {
+		if job.HeadSHA != "" {
+			dedupStore.AbandonReview(job.Provider, job.Repo, job.PRNumber, job.HeadSHA)
+		}
+		return nil
+	}
+
+	// Commands carry no head SHA and are not deduplicated
+	if job.HeadSHA == "" {
+		return err
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			dedupStore.AbandonReview(job.Provider, job.Repo, job.PRNumber, job.HeadSHA)
+		}
+		return err
+	}
+	dedupStore.CompleteReview(job.Provider, job.Repo, job.PRNumber, job.HeadSHA)
+	return nil
+}
+
+func processPR(ctx context.Context, job *queue.Job, run *store.Run) (err error) {
+	prNumber, repo := job.PRNumber, job.Repo
+	logger.LogPRReview(prNumber, repo, "started")
+
+	vcsProvider, err := providerFor(job)
+	if err != nil {
+		return err
+	}
+
+	// Report the review as a check on the head commit where the provider
+	// supports it. The check is completed as soon as the review is posted,
+	// or with the error when processing stops early. A job interrupted by
+	// shutdown leaves it running for the resumed job to complete.
+	reporter, _ := vcsProvider.(vcs.CheckReporter)
+	if reporter != nil && job.CheckID == "" && !job.ReviewPosted {
+		if job.CheckID = startCheck(ctx, reporter, job); job.CheckID != "" {
+			checkpoint(job)
+		}
+	}
+	defer func() {
+		if err == nil || reporter == nil || job.CheckID == "" || interrupted(ctx) {
+			return
+		}
+		completeCheck(ctx, reporter, job, err)
+		job.CheckID = ""
+	}()
+
+	if job.Review == "" {
+		// Review only what changed since the last review of this PR, if any.
+		// Reviews requested explicitly always cover the whole PR.
+		if last := lastReviewedSHA(ctx, job.Provider, repo, prNumber); last != "" && last != job.HeadSHA && job.HeadSHA != "" && !job.Forced {
+			job.BaseSHA = last
+		}
+
+		// Get PR changes
+		var changes []types.FileChange
+		err := runStage(ctx, run, "get changes", func() error {
+			var err error
+			if job.BaseSHA != "" {
+				changes, err = vcsProvider.GetChangesBetween(ctx, repo, prNumber, job.BaseSHA, job.HeadSHA)
+			} else {
+				changes, err = vcsProvider.GetChanges(ctx, repo, prNumber)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		logger.LogInfo("Retrieved %d files from PR #%d", len(changes), prNumber)
+
+		// Size limits apply to the whole PR, also when only the commits since
+		// the last review are reviewed
+		if !job.Forced && reviewPolicy.HasSizeLimits() {
+			prChanges := changes
+			if job.BaseSHA != "" {
+				err := runStage(ctx, run, "get PR size", func() error {
+					var err error
+					prChanges, err = vcsProvider.GetChanges(ctx, repo, prNumber)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+			}
+			if ok, reason := reviewPolicy.EvaluateSize(policy.CountDiffLines(prChanges)); !ok {
+				logger.LogInfo("Skipping PR #%d: %s", prNumber, reason)
+				return errReviewSkipped
+			}
+		}
+
+		// Get AI review
+		var review *types.Review
+		err = runStage(ctx, run, "review code", func() error {
+			var err error
+			review, err = aiProvider.ReviewCode(ctx, changes)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for i := range review.Findings {
+			review.Findings[i].Blocking = reviewPolicy.Blocking(review.Findings[i])
+		}
+
+		// Findings on lines the diff does not show cannot be posted inline
+		// and are listed in the review body instead
+		var unanchored []types.Finding
+		job.Findings, unanchored = anchorFindings(changes, review.Findings)
+		job.Review = review.Body + types.FormatFindings("Other findings", unanchored)
+		job.Verdict = reviewPolicy.Verdict(review)
+		if job.Verdict == types.VerdictApprove && job.BaseSHA != "" {
+			// An incremental review only sees the latest commits, so it must
+			// not approve over blocking findings of earlier reviews that no
+			// commit has addressed since
+			open, err := openBlockingFindings(ctx, vcsProvider, job)
+			switch {
+			case err != nil:
+				logger.LogError("Failed to check earlier blocking findings, not approving", err)
+				job.Verdict = types.VerdictComment
+			case open > 0:
+				logger.LogInfo("Not approving PR #%d: %d blocking findings of earlier reviews are still open", prNumber, open)
+				job.Verdict = types.VerdictComment
+			}
+		}
+		job.Review = coverageNote(changes) + job.Review
+		if job.BaseSHA != "" {
+			job.Review = incrementalReviewHeader(job) + job.Review
+		}
+		logger.LogInfo("Generated AI review for PR #%d with %d inline comments (verdict: %s)", prNumber, len(job.Findings), job.Verdict)
+		checkpoint(job)
+	}
+
+	if job.Summary == "" {
+		// Generate review summary
+		err := runStage(ctx, run, "generate summary", func() error {
+			var err error
+			job.Summary, err = aiProvider.GenerateReviewSummary(ctx, job.Review+types.FormatFindings("Findings", job.Findings))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		logger.LogInfo("Generated review summary for PR #%d", prNumber)
+		checkpoint(job)
+	}
+
+	if !job.ReviewPosted {
+		// Create review
+		err := runStage(ctx, run, "create review", func() error {
+			return vcsProvider.CreateReview(ctx, repo, prNumber, &types.Review{
+				Body:     job.Review,
+				Findings: job.Findings,
+				Verdict:  job.Verdict,
+				ID:       job.ID,
+				HeadSHA:  job.HeadSHA,
+				CheckID:  job.CheckID,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		job.ReviewPosted = true
+		checkpoint(job)
+		logger.LogPRReview(prNumber, repo, "review posted")
+	}
+
+	if job.CheckID != "" {
+		completeCheck(ctx, reporter, job, nil)
+		job.CheckID = ""
+		checkpoint(job)
+	}
+
+	// Send Slack notification. The review is already posted at this point,
+	// so a newer commit arriving no longer cancels the job, and a failure is
+	// only logged.
+	notifyErr := runStage(context.WithoutCancel(ctx), run, "send notification", func() error {
+		return slClient.SendPRReviewNotification(job.Title, job.URL, job.Summary)
+	})
+	if notifyErr != nil {
+		logger.LogError(fmt.Sprintf("Failed to send Slack notification for PR #%d", prNumber), notifyErr)
+	} else {
+		run.NotificationSent = true
+		logger.LogSlackNotification(os.Getenv("SLACK_CHANNEL_ID"), "PR review summary")
+	}
+
+	logger.LogPRReview(prNumber, repo, "completed")
+	return nil
+}
+
+// maxNamedFiles caps the files named in a coverage note
+const maxNamedFiles = 20
+
+// coverageNote returns a note naming the files whose diff was not available,
+// which the review could not cover. It is empty when the review covers
+// everything.
+func coverageNote(changes []types.FileChange) string {
+	var files []string
+	for _, change := range changes {
+		if change.TooLarge {
+			files = append(files, "`"+change.Path+"`")
+		}
+	}
+	if len(files) == 0 {
+		return ""
+	}
+
+	if len(files) > maxNamedFiles {
+		files = append(files[:maxNamedFiles:maxNamedFiles], fmt.Sprintf("and %d more", len(files)-maxNamedFiles))
+	}
+	return "> ⚠️ **Partial review**\n> Not reviewed because the diff is too large to retrieve: " + strings.Join(files, ", ") + "\n\n"
+}
+
+// startCheck starts a check for a review and returns its ID, or an empty ID
+// when the provider has checks disabled or starting it failed. A failed check
+// does not hold up the review.
+func startCheck(ctx context.Context, reporter vcs.CheckReporter, job *queue.Job) string {
+	checkID, err := reporter.StartCheck(ctx, job.Repo, job.PRNumber, job.HeadSHA)
+	if err != nil {
+		logger.LogError(fmt.Sprintf("Failed to start check for PR #%d", job.PRNumber), err)
+		return ""
+	}
+	return checkID
+}
+
+// completeCheck reports the outcome of a review on its check. The check is
+// completed even when the job was cancelled by a newer commit.
+func completeCheck(ctx context.Context, reporter vcs.CheckReporter, job *queue.Job, err error) {
+	result := &types.CheckResult{}
+	switch {
+	case errors.Is(err, errReviewSkipped):
+		result.Conclusion = types.CheckSkipped
+		result.Title = "Review skipped"
+		result.Summary = "The changes are outside the review policy. Comment `/ai-review` to review them anyway."
+	case err != nil && errors.Is(context.Cause(ctx), queue.ErrSuperseded):
+		result.Conclusion = types.CheckCancelled
+		result.Title = "Review cancelled"
+		result.Summary = "A newer commit was pushed before the review finished."
+	case err != nil:
+		result.Conclusion = types.CheckFailure
+		result.Title = "Review failed"
+		result.Summary = fmt.Sprintf("The review could not be completed: %v\n\nComment `/ai-review` to try again.", err)
+	default:
+		result.Conclusion, result.Title = checkConclusion(job.Verdict, len(job.Findings))
+		result.Summary = job.Review + types.FormatFindings("Findings", job.Findings)
+		result.Findings = job.Findings
+	}
+
+	if err := reporter.CompleteCheck(context.WithoutCancel(ctx), job.Repo, job.PRNumber, job.CheckID, result); err != nil {
+		logger.LogError(fmt.Sprintf("Failed to complete check for PR #%d", job.PRNumber), err)
+	}
+}
+
+// interrupted reports whether a job was cancelled by shutdown rather than by
+// a newer commit
+func interrupted(ctx context.Context) bool {
+	return ctx.Err() != nil && !errors.Is(context.Cause(ctx), queue.ErrSuperseded)
+}
+
+// checkConclusion returns the conclusion and title of a check for a review
+// verdict
+func checkConclusion(verdict types.Verdict, findings int) (types.CheckConclusion, string) {
+	switch verdict {
+	case types.VerdictApprove:
+		if findings == 0 {
+			return types.CheckSuccess, "Approved"
+		}
+		return types.CheckSuccess, fmt.Sprintf("Approved with %d findings", findings)
+	case types.VerdictRequestChanges:
+		return types.CheckFailure, fmt.Sprintf("Changes requested: %d findings", findings)
+	default:
+		return types.CheckNeutral, fmt.Sprintf("Reviewed: %d findings", findings)
+	}
+}
+
+// anchorFindings matches findings to lines of the changes that inline
+// comments can be attached to, and returns the findings on lines the diff
+// does not show separately
+func anchorFindings(changes []types.FileChange, findings []types.Finding) (anchored, unanchored []types.Finding) {
+	lines := make(map[string]map[int]diffutil.Line)
+	for _, change := range changes {
+		lines[change.Path] = diffutil.Lines(change.Hunks)
+	}
+
+	for _, finding := range findings {
+		finding.File = strings.TrimPrefix(strings.TrimPrefix(finding.File, "./"), "/")
+		line, ok := lines[finding.File][finding.Line]
+		if !ok {
+			unanchored = append(unanchored, finding)
+			continue
+		}
+		finding.OldLine = line.Old
+
+		// A range, and the suggestion replacing it, can only be posted when
+		// it lies inside one hunk. Otherwise the comment goes on the last
+		// line and the suggestion is shown as plain code.
+		if first := finding.FirstLine(); first == finding.Line {
+			finding.StartLine = 0
+		} else if !diffutil.InOneHunk(lines[finding.File], first, finding.Line) {
+			finding.StartLine = 0
+			if finding.Suggestion != "" {
+				finding.Body = finding.CommentBody("")
+				finding.Suggestion = ""
+			}
+		}
+		anchored = append(anchored, finding)
+	}
+	return anchored, unanchored
+}
+
+// providerFor returns the VCS provider of the host a job came from. Jobs
+// queued before multiple providers were supported carry no provider and go
+// to the only configured host.
+func providerFor(job *queue.Job) (vcs.Provider, error) {
+	if hosts := vcsHosts.Hosts(); job.Provider == "" && len(hosts) == 1 {
+		job.Provider = hosts[0].Name
+	}
+
+	host, ok := vcsHosts.Get(job.Provider)
+	if !ok {
+		return nil, retry.Permanent(fmt.Errorf("VCS host not configured: %s", job.Provider))
+	}
+	return host.Provider, nil
+}
+
+// runStage runs a review stage with retries and records how long it took
+func runStage(ctx context.Context, run *store.Run, stage string, fn func() error) error {
+	start := time.Now()
+	err := retry.Do(ctx, stage, fn)
+	run.Stages = append(run.Stages, store.StageTiming{Stage: stage, Duration: time.Since(start)})
+	return err
+}
+
+// lastReviewedSHA returns the head SHA of the last completed review of a PR,
+// falling back to the review history once the dedup window has passed
+func lastReviewedSHA(ctx context.Context, provider, repo string, prNumber int) string {
+	if sha := dedupStore.LastReviewedSHA(provider, repo, prNumber); sha != "" {
+		return sha
+	}
+
+	run, err := reviewStore.LastCompletedRun(ctx, provider, repo, prNumber)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			logger.LogError("Failed to look up last review run", err)
+		}
+		return ""
+	}
+	return run.HeadSHA
+}
+
+// openBlockingFindings counts the blocking findings of the reviews since the
+// last full review of a PR whose lines have not changed since. Their lines
+// are looked up in the changes from the head each review covered to the head
+// of the job.
+func openBlockingFindings(ctx context.Context, vcsProvider vcs.Provider, job *queue.Job) (int, error) {
+	runs, err := reviewStore.ListRuns(ctx, job.Provider, job.Repo, job.PRNumber)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list earlier review runs: %w", err)
+	}
+
+	open := 0
+	for _, run := range runs {
+		if run.Status != store.RunCompleted || !run.ReviewPosted {
+			continue
+		}
+
+		var blocking []store.Finding
+		for _, finding := range run.Findings {
+			if finding.Blocking {
+				blocking = append(blocking, finding)
+			}
+		}
+		if len(blocking) > 0 {
+			changes, err := vcsProvider.GetChangesBetween(ctx, job.Repo, job.PRNumber, run.HeadSHA, job.HeadSHA)
+			if err != nil {
+				return 0, err
+			}
+			for _, finding := range blocking {
+				if !diffutil.Touched(changes, finding.File, finding.Line, finding.Line) {
+					open++
+				}
+			}
+		}
+
+		if run.BaseSHA == "" {
+			break
+		}
+	}
+	return open, nil
+}
+
+// saveRun records a review run in the history store. Failures are logged
+// and never fail the review itself.
+func saveRun(ctx context.Context, run *store.Run) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	if err := reviewStore.SaveRun(ctx, run); err != nil {
+		logger.LogError("Failed to save review run "+run.ID, err)
+	}
+}
+
+// incrementalReviewHeader introduces a review that only covers the commits
+// pushed since the previous review
+func incrementalReviewHeader(job *queue.Job) string {
+	return fmt.Sprintf("**Incremental review** of changes from `%s` to `%s`. "+
+		"Changes before `%s` were covered by the previous review on this PR.\n\n",
+		shortSHA(job.BaseSHA), shortSHA(job.HeadSHA), shortSHA(job.BaseSHA))
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// checkpoint records a job's progress so a retry or restart resumes from the
+// last completed stage
+func checkpoint(job *queue.Job) {
+	if err := reviewQueue.Checkpoint(job); err != nil {
+		logger.LogError("Failed to checkpoint job "+job.ID, err)
+	}
+}
+
+// runFindings converts the findings of a review for the history store
+func runFindings(findings []types.Finding) []store.Finding {
+	var result []store.Finding
+	for _, finding := range findings {
+		result = append(result, store.Finding{
+			File:     finding.File,
+			Line:     finding.Line,
+			Severity: finding.Severity,
+			Message:  finding.Body,
+			Blocking: finding.Blocking,
+		})
+	}
+	return result
+}
@@ -36,7 +36,7 @@ func (c *Client) SendPRReviewNotification(prTitle, prURL, reviewSummary string)
 	)
 	if err != nil {
 		logger.LogError("Failed to send Slack message", err)
-		return fmt.Errorf("failed to send Slack message: %v", err)
+		return fmt.Errorf("failed to send Slack message: %w", err)
 	}
 
 	logger.LogInfo("Successfully sent Slack notification to channel %s", channelID)
@@ -0,0 +1,166 @@
+package sticky
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Hidden markers the bot leaves in its comments so that it can find them
+// again. They render as nothing in GitHub and GitLab markdown.
+const (
+	// Marker starts the bot's summary comment
+	Marker = "<!-- pr-agent-reviewer:summary -->"
+	// FindingMarker ends an inline finding that is still current
+	FindingMarker = "<!-- pr-agent-reviewer:finding -->"
+	// BlockingMarker ends an inline finding that is still current and
+	// requested changes
+	BlockingMarker = "<!-- pr-agent-reviewer:finding blocking -->"
+
+	outdatedMarker = "<!-- pr-agent-reviewer:finding outdated -->"
+	roundPrefix    = "<!-- pr-agent-reviewer:round "
+	historyMarker  = "<!-- pr-agent-reviewer:history -->"
+	earlierMarker  = "<!-- pr-agent-reviewer:earlier -->"
+	tagPrefix      = "<!-- pr-agent-reviewer:review "
+)
+
+// maxLength keeps the summary comment below the size limits of GitHub
+// (65536 characters) and GitLab (1000000); the oldest rounds are dropped
+// first
+const maxLength = 60000
+
+// IsSummary reports whether a comment is the bot's summary comment
+func IsSummary(body string) bool {
+	return strings.HasPrefix(body, Marker)
+}
+
+// Heading introduces a review round in the summary comment
+func Heading(headSHA string, at time.Time) string {
+	if len(headSHA) > 7 {
+		headSHA = headSHA[:7]
+	}
+	if headSHA == "" {
+		return fmt.Sprintf("## 🤖 AI review (%s)", at.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	return fmt.Sprintf("## 🤖 AI review of `%s` (%s)", headSHA, at.UTC().Format("2006-01-02 15:04 MST"))
+}
+
+// Compose returns the summary comment for a review round. previous is the
+// current body of the summary comment, or empty when there is none. Its
+// latest round moves into a collapsed history, unless it has the same round
+// ID, in which case it is a retry of the same review and is replaced.
+func Compose(previous, roundID, heading, review string) string {
+	latestID, latest, history := parse(previous)
+	if latest != "" && latestID != roundID {
+		history = append([]string{latest}, history...)
+	}
+
+	current := heading + "\n\n" + strings.TrimSpace(review)
+	body := render(roundID, current, history)
+	for len(body) > maxLength && len(history) > 0 {
+		history = history[:len(history)-1]
+		body = render(roundID, current, history)
+	}
+	return body
+}
+
+// parse splits a summary comment into the ID and text of its latest round
+// and the earlier rounds, newest first
+func parse(body string) (string, string, []string) {
+	if !IsSummary(body) {
+		return "", "", nil
+	}
+
+	body = strings.TrimPrefix(body, Marker)
+	current, rest, _ := strings.Cut(body, historyMarker)
+
+	current = strings.TrimSpace(current)
+	var roundID string
+	if strings.HasPrefix(current, roundPrefix) {
+		line, text, _ := strings.Cut(current, "\n")
+		roundID = strings.TrimSuffix(strings.TrimPrefix(line, roundPrefix), " -->")
+		current = strings.TrimSpace(text)
+	}
+
+	rest = strings.TrimSuffix(strings.TrimSpace(rest), "</details>")
+	var history []string
+	for _, round := range strings.Split(rest, earlierMarker)[1:] {
+		round = strings.TrimSpace(round)
+		if round != "" {
+			history = append(history, round)
+		}
+	}
+	return roundID, current, history
+}
+
+func render(roundID, current string, history []string) string {
+	var b strings.Builder
+	b.WriteString(Marker + "\n")
+	b.WriteString(roundPrefix + roundID + " -->\n")
+	b.WriteString(current)
+	if len(history) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("\n\n" + historyMarker + "\n")
+	b.WriteString(fmt.Sprintf("<details>\n<summary>Earlier reviews (%d)</summary>\n", len(history)))
+	for _, round := range history {
+		b.WriteString("\n" + earlierMarker + "\n" + round + "\n")
+	}
+	b.WriteString("\n</details>")
+	return b.String()
+}
+
+// Finding marks the body of an inline finding so that it can be marked
+// outdated by a later review
+func Finding(body string, blocking bool) string {
+	if blocking {
+		return body + "\n\n" + BlockingMarker
+	}
+	return body + "\n\n" + FindingMarker
+}
+
+// IsFinding reports whether a comment is an inline finding of the bot that
+// has not been marked outdated
+func IsFinding(body string) bool {
+	body = strings.TrimSpace(body)
+	return strings.HasSuffix(body, FindingMarker) || strings.HasSuffix(body, BlockingMarker)
+}
+
+// IsBlocking reports whether a comment is an inline finding of the bot that
+// requested changes and has not been marked outdated
+func IsBlocking(body string) bool {
+	return strings.HasSuffix(strings.TrimSpace(body), BlockingMarker)
+}
+
+// Outdated returns the body of an inline finding collapsed and marked as
+// superseded by a newer review
+func Outdated(body string) string {
+	text := strings.TrimSpace(body)
+	text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(text, FindingMarker), BlockingMarker))
+	return "<details>\n<summary>Outdated: superseded by a newer AI review</summary>\n\n" +
+		text + "\n\n</details>\n\n" + outdatedMarker
+}
+
+// Tag marks a comment posted for a review with the review ID and the part of
+// the review it holds, e.g. the file and line of a finding, so that a retry
+// of the review can tell which of its comments are already posted
+func Tag(body, reviewID, part string) string {
+	return body + "\n\n" + tagPrefix + reviewID + " " + part + " -->"
+}
+
+// Tagged returns the part of the review with the given ID that a comment
+// holds, and whether the comment was tagged for that review
+func Tagged(body, reviewID string) (string, bool) {
+	body = strings.TrimSpace(body)
+	i := strings.LastIndex(body, tagPrefix)
+	if i < 0 || !strings.HasSuffix(body, " -->") {
+		return "", false
+	}
+	tag := strings.TrimSuffix(body[i+len(tagPrefix):], " -->")
+	id, part, ok := strings.Cut(tag, " ")
+	if !ok || id != reviewID {
+		return "", false
+	}
+	return part, true
+}
@@ -0,0 +1,190 @@
+package sticky
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompose(t *testing.T) {
+	first := Compose("", "job-1", "## Round 1", "First review")
+	second := Compose(first, "job-2", "## Round 2", "Second review")
+
+	tests := []struct {
+		name        string
+		previous    string
+		roundID     string
+		review      string
+		wantLatest  string
+		wantHistory []string
+	}{
+		{
+			name:       "first round",
+			previous:   "",
+			roundID:    "job-1",
+			review:     "First review",
+			wantLatest: "## Round 1\n\nFirst review",
+		},
+		{
+			name:       "previous comment is not a summary",
+			previous:   "Some other comment",
+			roundID:    "job-1",
+			review:     "First review",
+			wantLatest: "## Round 1\n\nFirst review",
+		},
+		{
+			name:       "retry of the same round replaces it",
+			previous:   first,
+			roundID:    "job-1",
+			review:     "First review, with findings",
+			wantLatest: "## Round 1\n\nFirst review, with findings",
+		},
+		{
+			name:        "new round moves the latest into the history",
+			previous:    first,
+			roundID:     "job-2",
+			review:      "Second review",
+			wantLatest:  "## Round 2\n\nSecond review",
+			wantHistory: []string{"## Round 1\n\nFirst review"},
+		},
+		{
+			name:        "history is kept newest first",
+			previous:    second,
+			roundID:     "job-3",
+			review:      "Third review",
+			wantLatest:  "## Round 3\n\nThird review",
+			wantHistory: []string{"## Round 2\n\nSecond review", "## Round 1\n\nFirst review"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heading := "## Round " + strings.TrimPrefix(tt.roundID, "job-")
+			body := Compose(tt.previous, tt.roundID, heading, tt.review)
+			if !IsSummary(body) {
+				t.Fatalf("Compose() = %q, want a summary comment", body)
+			}
+
+			roundID, latest, history := parse(body)
+			if roundID != tt.roundID {
+				t.Errorf("round ID = %q, want %q", roundID, tt.roundID)
+			}
+			if latest != tt.wantLatest {
+				t.Errorf("latest round = %q, want %q", latest, tt.wantLatest)
+			}
+			if !reflect.DeepEqual(history, tt.wantHistory) {
+				t.Errorf("history = %q, want %q", history, tt.wantHistory)
+			}
+			if want := fmt.Sprintf("Earlier reviews (%d)", len(tt.wantHistory)); len(tt.wantHistory) > 0 && !strings.Contains(body, want) {
+				t.Errorf("Compose() = %q, want it to list %q", body, want)
+			}
+		})
+	}
+}
+
+func TestComposeDropsOldestRounds(t *testing.T) {
+	body := ""
+	review := strings.Repeat("x", maxLength/4)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6"} {
+		body = Compose(body, id, "## Round "+id, review)
+	}
+
+	if len(body) > maxLength {
+		t.Fatalf("len(Compose()) = %d, want at most %d", len(body), maxLength)
+	}
+	roundID, _, history := parse(body)
+	if roundID != "6" {
+		t.Errorf("round ID = %q, want %q", roundID, "6")
+	}
+	if len(history) == 0 || !strings.HasPrefix(history[0], "## Round 5") {
+		t.Errorf("history = %d rounds, want the newest earlier round first", len(history))
+	}
+	if strings.Contains(body, "## Round 1\n") {
+		t.Errorf("Compose() kept the oldest round")
+	}
+}
+
+func TestFindingMarkers(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantFinding  bool
+		wantBlocking bool
+	}{
+		{name: "finding", body: Finding("Use a constant", false), wantFinding: true},
+		{name: "finding with trailing whitespace", body: Finding("Use a constant", false) + "\n", wantFinding: true},
+		{name: "blocking finding", body: Finding("SQL injection", true), wantFinding: true, wantBlocking: true},
+		{name: "outdated finding", body: Outdated(Finding("Use a constant", false))},
+		{name: "outdated blocking finding", body: Outdated(Finding("SQL injection", true))},
+		{name: "other comment", body: "LGTM"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsFinding(tt.body); got != tt.wantFinding {
+				t.Errorf("IsFinding(%q) = %t, want %t", tt.body, got, tt.wantFinding)
+			}
+			if got := IsBlocking(tt.body); got != tt.wantBlocking {
+				t.Errorf("IsBlocking(%q) = %t, want %t", tt.body, got, tt.wantBlocking)
+			}
+		})
+	}
+}
+
+func TestOutdatedKeepsTheFinding(t *testing.T) {
+	for _, blocking := range []bool{false, true} {
+		body := Outdated(Finding("Use a constant", blocking))
+		if !strings.Contains(body, "Use a constant") || strings.Contains(body, FindingMarker) || strings.Contains(body, BlockingMarker) {
+			t.Errorf("Outdated() = %q, want the finding text without the finding marker", body)
+		}
+	}
+}
+
+func TestHeading(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		headSHA string
+		want    string
+	}{
+		{name: "short SHA", headSHA: "abc123", want: "## 🤖 AI review of `abc123` (2024-05-01 12:30 UTC)"},
+		{name: "full SHA is abbreviated", headSHA: "0123456789abcdef", want: "## 🤖 AI review of `0123456` (2024-05-01 12:30 UTC)"},
+		{name: "no SHA", headSHA: "", want: "## 🤖 AI review (2024-05-01 12:30 UTC)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Heading(tt.headSHA, at); got != tt.want {
+				t.Errorf("Heading() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTagged(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		reviewID string
+		wantPart string
+		wantOK   bool
+	}{
+		{name: "review body", body: Tag("Looks good", "job-1", "review"), reviewID: "job-1", wantPart: "review", wantOK: true},
+		{name: "finding", body: Tag("Nit", "job-1", "main.go:12"), reviewID: "job-1", wantPart: "main.go:12", wantOK: true},
+		{name: "other review", body: Tag("Looks good", "job-1", "review"), reviewID: "job-2"},
+		{name: "trailing whitespace", body: Tag("Nit", "job-1", "main.go:12") + "\n", reviewID: "job-1", wantPart: "main.go:12", wantOK: true},
+		{name: "untagged", body: "Looks good", reviewID: "job-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			part, ok := Tagged(tt.body, tt.reviewID)
+			if part != tt.wantPart || ok != tt.wantOK {
+				t.Errorf("Tagged() = %q, %t, want %q, %t", part, ok, tt.wantPart, tt.wantOK)
+			}
+		})
+	}
+}
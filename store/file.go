@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"pr-agent-reviewer/logger"
+)
+
+// FileStore is an embedded store that keeps one JSON file per review run
+type FileStore struct {
+	dir string
+
+	mu   sync.RWMutex
+	runs map[string]*Run
+}
+
+// NewFileStore creates a new file store and loads the runs already on disk
+func NewFileStore() (*FileStore, error) {
+	dir := os.Getenv("STORE_DIR")
+	if dir == "" {
+		dir = filepath.Join("data", "runs")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+
+	s := &FileStore{dir: dir, runs: make(map[string]*Run)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			logger.LogError("Failed to read review run "+entry.Name(), err)
+			continue
+		}
+
+		var run Run
+		if err := json.Unmarshal(data, &run); err != nil {
+			logger.LogError("Failed to decode review run "+entry.Name(), err)
+			continue
+		}
+		s.runs[run.ID] = &run
+	}
+
+	logger.LogInfo("Initialized file store in %s (%d runs)", dir, len(s.runs))
+	return s, nil
+}
+
+// SaveRun implements the Store interface
+func (s *FileStore) SaveRun(ctx context.Context, run *Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to marshal review run: %v", err)
+	}
+
+	path := filepath.Join(s.dir, filepath.Base(run.ID)+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write review run: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to persist review run: %v", err)
+	}
+
+	saved := *run
+	s.mu.Lock()
+	s.runs[run.ID] = &saved
+	s.mu.Unlock()
+	return nil
+}
+
+// GetRun implements the Store interface
+func (s *FileStore) GetRun(ctx context.Context, id string) (*Run, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *run
+	return &copied, nil
+}
+
+// ListRuns implements the Store interface
+func (s *FileStore) ListRuns(ctx context.Context, provider, repo string, prNumber int) ([]*Run, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var runs []*Run
+	for _, run := range s.runs {
+		if run.Provider == provider && run.Repo == repo && run.PRNumber == prNumber {
+			copied := *run
+			runs = append(runs, &copied)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return runs, nil
+}
+
+// LastCompletedRun implements the Store interface
+func (s *FileStore) LastCompletedRun(ctx context.Context, provider, repo string, prNumber int) (*Run, error) {
+	runs, err := s.ListRuns(ctx, provider, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, run := range runs {
+		if run.Status == RunCompleted {
+			return run, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Close implements the Store interface
+func (s *FileStore) Close() error {
+	return nil
+}
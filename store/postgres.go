@@ -0,0 +1,328 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"pr-agent-reviewer/logger"
+
+	_ "github.com/lib/pq"
+)
+
+// migrations are applied in order; each one runs once and is recorded in
+// the schema_migrations table. Never edit a migration that has shipped,
+// append a new one instead.
+var migrations = []string{
+	// 1: review runs, findings and stage timings
+	`CREATE TABLE review_runs (
+		id                TEXT PRIMARY KEY,
+		job_id            TEXT NOT NULL,
+		repo              TEXT NOT NULL,
+		pr_number         INTEGER NOT NULL,
+		head_sha          TEXT NOT NULL,
+		base_sha          TEXT NOT NULL DEFAULT '',
+		status            TEXT NOT NULL,
+		review            TEXT NOT NULL DEFAULT '',
+		summary           TEXT NOT NULL DEFAULT '',
+		prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		review_posted     BOOLEAN NOT NULL DEFAULT FALSE,
+		notification_sent BOOLEAN NOT NULL DEFAULT FALSE,
+		error             TEXT NOT NULL DEFAULT '',
+		started_at        TIMESTAMPTZ NOT NULL,
+		finished_at       TIMESTAMPTZ
+	);
+	CREATE INDEX review_runs_pr_idx ON review_runs (repo, pr_number, started_at DESC);
+
+	CREATE TABLE review_findings (
+		run_id   TEXT NOT NULL REFERENCES review_runs (id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		file     TEXT NOT NULL,
+		line     INTEGER NOT NULL DEFAULT 0,
+		severity TEXT NOT NULL DEFAULT '',
+		message  TEXT NOT NULL,
+		PRIMARY KEY (run_id, position)
+	);
+
+	CREATE TABLE review_stage_timings (
+		run_id      TEXT NOT NULL REFERENCES review_runs (id) ON DELETE CASCADE,
+		position    INTEGER NOT NULL,
+		stage       TEXT NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		PRIMARY KEY (run_id, position)
+	);`,
+
+	// 2: VCS provider of each run
+	`ALTER TABLE review_runs ADD COLUMN provider TEXT NOT NULL DEFAULT 'github';
+	DROP INDEX review_runs_pr_idx;
+	CREATE INDEX review_runs_pr_idx ON review_runs (provider, repo, pr_number, started_at DESC);`,
+
+	// 3: findings that requested changes
+	`ALTER TABLE review_findings ADD COLUMN blocking BOOLEAN NOT NULL DEFAULT FALSE;`,
+}
+
+// PostgresStore stores review history in Postgres
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore connects to Postgres and applies pending migrations
+func NewPostgresStore() (*PostgresStore, error) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		return nil, fmt.Errorf("DATABASE_URL environment variable is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	s := &PostgresStore{db: db}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	logger.LogInfo("Initialized Postgres store")
+	return s, nil
+}
+
+// migrate applies the migrations that have not run yet
+func (s *PostgresStore) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %v", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %v", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %v", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %v", version, err)
+		}
+		logger.LogInfo("Applied database migration %d", version)
+	}
+
+	return nil
+}
+
+// SaveRun implements the Store interface
+func (s *PostgresStore) SaveRun(ctx context.Context, run *Run) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO review_runs (
+			id, job_id, repo, pr_number, head_sha, base_sha, status, review, summary,
+			prompt_tokens, completion_tokens, review_posted, notification_sent, error,
+			started_at, finished_at, provider
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		ON CONFLICT (id) DO UPDATE SET
+			status = EXCLUDED.status,
+			base_sha = EXCLUDED.base_sha,
+			review = EXCLUDED.review,
+			summary = EXCLUDED.summary,
+			prompt_tokens = EXCLUDED.prompt_tokens,
+			completion_tokens = EXCLUDED.completion_tokens,
+			review_posted = EXCLUDED.review_posted,
+			notification_sent = EXCLUDED.notification_sent,
+			error = EXCLUDED.error,
+			finished_at = EXCLUDED.finished_at`,
+		run.ID, run.JobID, run.Repo, run.PRNumber, run.HeadSHA, run.BaseSHA, string(run.Status),
+		run.Review, run.Summary, run.PromptTokens, run.CompletionTokens, run.ReviewPosted,
+		run.NotificationSent, run.Error, run.StartedAt, nullTime(run.FinishedAt), run.Provider,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save review run: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM review_findings WHERE run_id = $1`, run.ID); err != nil {
+		return fmt.Errorf("failed to clear review findings: %v", err)
+	}
+	for i, finding := range run.Findings {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO review_findings (run_id, position, file, line, severity, message, blocking) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			run.ID, i, finding.File, finding.Line, finding.Severity, finding.Message, finding.Blocking,
+		); err != nil {
+			return fmt.Errorf("failed to save review finding: %v", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM review_stage_timings WHERE run_id = $1`, run.ID); err != nil {
+		return fmt.Errorf("failed to clear stage timings: %v", err)
+	}
+	for i, stage := range run.Stages {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO review_stage_timings (run_id, position, stage, duration_ms) VALUES ($1, $2, $3, $4)`,
+			run.ID, i, stage.Stage, stage.Duration.Milliseconds(),
+		); err != nil {
+			return fmt.Errorf("failed to save stage timing: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit review run: %v", err)
+	}
+	return nil
+}
+
+const selectRun = `
+	SELECT id, job_id, repo, pr_number, head_sha, base_sha, status, review, summary,
+		prompt_tokens, completion_tokens, review_posted, notification_sent, error,
+		started_at, finished_at, provider
+	FROM review_runs`
+
+// GetRun implements the Store interface
+func (s *PostgresStore) GetRun(ctx context.Context, id string) (*Run, error) {
+	runs, err := s.queryRuns(ctx, selectRun+` WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, ErrNotFound
+	}
+	return runs[0], nil
+}
+
+// ListRuns implements the Store interface
+func (s *PostgresStore) ListRuns(ctx context.Context, provider, repo string, prNumber int) ([]*Run, error) {
+	return s.queryRuns(ctx,
+		selectRun+` WHERE provider = $1 AND repo = $2 AND pr_number = $3 ORDER BY started_at DESC`,
+		provider, repo, prNumber)
+}
+
+// LastCompletedRun implements the Store interface
+func (s *PostgresStore) LastCompletedRun(ctx context.Context, provider, repo string, prNumber int) (*Run, error) {
+	runs, err := s.queryRuns(ctx,
+		selectRun+` WHERE provider = $1 AND repo = $2 AND pr_number = $3 AND status = $4 ORDER BY started_at DESC LIMIT 1`,
+		provider, repo, prNumber, string(RunCompleted))
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, ErrNotFound
+	}
+	return runs[0], nil
+}
+
+// Close implements the Store interface
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// queryRuns runs a review_runs query and loads the findings and stage
+// timings of every returned run
+func (s *PostgresStore) queryRuns(ctx context.Context, query string, args ...interface{}) ([]*Run, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query review runs: %v", err)
+	}
+	defer rows.Close()
+
+	var runs []*Run
+	for rows.Next() {
+		var run Run
+		var status string
+		var finishedAt sql.NullTime
+		if err := rows.Scan(
+			&run.ID, &run.JobID, &run.Repo, &run.PRNumber, &run.HeadSHA, &run.BaseSHA, &status,
+			&run.Review, &run.Summary, &run.PromptTokens, &run.CompletionTokens, &run.ReviewPosted,
+			&run.NotificationSent, &run.Error, &run.StartedAt, &finishedAt, &run.Provider,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan review run: %v", err)
+		}
+		run.Status = RunStatus(status)
+		if finishedAt.Valid {
+			run.FinishedAt = finishedAt.Time
+		}
+		runs = append(runs, &run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read review runs: %v", err)
+	}
+
+	for _, run := range runs {
+		if err := s.loadDetails(ctx, run); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+// loadDetails loads the findings and stage timings of a run
+func (s *PostgresStore) loadDetails(ctx context.Context, run *Run) error {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT file, line, severity, message, blocking FROM review_findings WHERE run_id = $1 ORDER BY position`, run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to query review findings: %v", err)
+	}
+	for rows.Next() {
+		var finding Finding
+		if err := rows.Scan(&finding.File, &finding.Line, &finding.Severity, &finding.Message, &finding.Blocking); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan review finding: %v", err)
+		}
+		run.Findings = append(run.Findings, finding)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read review findings: %v", err)
+	}
+
+	rows, err = s.db.QueryContext(ctx,
+		`SELECT stage, duration_ms FROM review_stage_timings WHERE run_id = $1 ORDER BY position`, run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to query stage timings: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stage StageTiming
+		var durationMs int64
+		if err := rows.Scan(&stage.Stage, &durationMs); err != nil {
+			return fmt.Errorf("failed to scan stage timing: %v", err)
+		}
+		stage.Duration = time.Duration(durationMs) * time.Millisecond
+		run.Stages = append(run.Stages, stage)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read stage timings: %v", err)
+	}
+	return nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned when a requested run does not exist
+var ErrNotFound = errors.New("review run not found")
+
+// BackendType represents the type of storage backend
+type BackendType string
+
+const (
+	// BackendFile represents the embedded file backend
+	BackendFile BackendType = "file"
+	// BackendPostgres represents the Postgres backend
+	BackendPostgres BackendType = "postgres"
+)
+
+// RunStatus represents the outcome of a review run
+type RunStatus string
+
+const (
+	// RunRunning means the review is still being processed
+	RunRunning RunStatus = "running"
+	// RunCompleted means the review was posted and the notification sent
+	RunCompleted RunStatus = "completed"
+	// RunFailed means the review gave up on a stage
+	RunFailed RunStatus = "failed"
+	// RunCancelled means the review was superseded or interrupted
+	RunCancelled RunStatus = "cancelled"
+	// RunSkipped means the review policy ruled the changes out after they
+	// were fetched
+	RunSkipped RunStatus = "skipped"
+)
+
+// Finding is a single issue reported by a review
+type Finding struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Message  string `json:"message"`
+	// Blocking is set when the finding made the review request changes
+	Blocking bool `json:"blocking,omitempty"`
+}
+
+// StageTiming records how long a review stage took
+type StageTiming struct {
+	Stage    string        `json:"stage"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Run is a single attempt at reviewing a pull/merge request
+type Run struct {
+	ID       string `json:"id"`
+	JobID    string `json:"job_id"`
+	Provider string `json:"provider"`
+	Repo     string `json:"repo"`
+	PRNumber int    `json:"pr_number"`
+	HeadSHA  string `json:"head_sha"`
+	BaseSHA  string `json:"base_sha,omitempty"`
+
+	Status   RunStatus `json:"status"`
+	Review   string    `json:"review,omitempty"`
+	Summary  string    `json:"summary,omitempty"`
+	Findings []Finding `json:"findings,omitempty"`
+
+	PromptTokens     int           `json:"prompt_tokens"`
+	CompletionTokens int           `json:"completion_tokens"`
+	Stages           []StageTiming `json:"stages,omitempty"`
+
+	ReviewPosted     bool   `json:"review_posted"`
+	NotificationSent bool   `json:"notification_sent"`
+	Error            string `json:"error,omitempty"`
+
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// Store defines the interface for review history backends
+type Store interface {
+	// SaveRun creates or updates a review run
+	SaveRun(ctx context.Context, run *Run) error
+
+	// GetRun gets a review run by ID
+	GetRun(ctx context.Context, id string) (*Run, error)
+
+	// ListRuns lists the review runs of a pull/merge request, newest first
+	ListRuns(ctx context.Context, provider, repo string, prNumber int) ([]*Run, error)
+
+	// LastCompletedRun gets the most recent completed run of a pull/merge request
+	LastCompletedRun(ctx context.Context, provider, repo string, prNumber int) (*Run, error)
+
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// NewStore creates a new review history store based on the configuration
+func NewStore() (Store, error) {
+	backend := BackendType(os.Getenv("STORE_BACKEND"))
+	if backend == "" {
+		backend = BackendFile // Default to the embedded file store
+	}
+
+	switch backend {
+	case BackendFile:
+		return NewFileStore()
+	case BackendPostgres:
+		return NewPostgresStore()
+	default:
+		return nil, fmt.Errorf("unsupported store backend: %s", backend)
+	}
+}
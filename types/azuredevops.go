@@ -0,0 +1,38 @@
+package types
+
+// AzureDevOpsPullRequestEvent is the service hook payload sent when a pull
+// request is created or updated
+type AzureDevOpsPullRequestEvent struct {
+	ID        string `json:"id"`
+	EventType string `json:"eventType"`
+	Resource  struct {
+		PullRequestID int    `json:"pullRequestId"`
+		Status        string `json:"status"`
+		Title         string `json:"title"`
+		IsDraft       bool   `json:"isDraft"`
+		TargetRefName string `json:"targetRefName"`
+		CreatedBy     struct {
+			ID         string `json:"id"`
+			UniqueName string `json:"uniqueName"`
+		} `json:"createdBy"`
+		LastMergeSourceCommit struct {
+			CommitID string `json:"commitId"`
+		} `json:"lastMergeSourceCommit"`
+		Labels []struct {
+			Name   string `json:"name"`
+			Active bool   `json:"active"`
+		} `json:"labels"`
+		Repository struct {
+			Name      string `json:"name"`
+			RemoteURL string `json:"remoteUrl"`
+			Project   struct {
+				Name string `json:"name"`
+			} `json:"project"`
+		} `json:"repository"`
+	} `json:"resource"`
+}
+
+// Repo returns the "project/repository" name of the pull request's repository
+func (e *AzureDevOpsPullRequestEvent) Repo() string {
+	return e.Resource.Repository.Project.Name + "/" + e.Resource.Repository.Name
+}
@@ -0,0 +1,71 @@
+package types
+
+// BitbucketUser is the user block shared by Bitbucket event payloads
+type BitbucketUser struct {
+	UUID        string `json:"uuid"`
+	AccountID   string `json:"account_id"`
+	Nickname    string `json:"nickname"`
+	DisplayName string `json:"display_name"`
+	Type        string `json:"type"`
+}
+
+// BitbucketRepository is the repository block shared by Bitbucket event
+// payloads
+type BitbucketRepository struct {
+	FullName string `json:"full_name"`
+}
+
+// BitbucketPullRequest is the pull request block shared by Bitbucket event
+// payloads
+type BitbucketPullRequest struct {
+	ID     int           `json:"id"`
+	Title  string        `json:"title"`
+	State  string        `json:"state"`
+	Draft  bool          `json:"draft"`
+	Author BitbucketUser `json:"author"`
+	Source struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+		Commit struct {
+			Hash string `json:"hash"`
+		} `json:"commit"`
+	} `json:"source"`
+	Destination struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+	} `json:"destination"`
+	Links struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+// BitbucketPullRequestEvent is sent when a pull request is created or updated
+type BitbucketPullRequestEvent struct {
+	Actor       BitbucketUser        `json:"actor"`
+	PullRequest BitbucketPullRequest `json:"pullrequest"`
+	Repository  BitbucketRepository  `json:"repository"`
+}
+
+// BitbucketCommentEvent is sent when a comment on a pull request is created
+type BitbucketCommentEvent struct {
+	Actor   BitbucketUser `json:"actor"`
+	Comment struct {
+		ID      int64 `json:"id"`
+		Content struct {
+			Raw string `json:"raw"`
+		} `json:"content"`
+		Parent *struct {
+			ID int64 `json:"id"`
+		} `json:"parent"`
+		Inline *struct {
+			Path string `json:"path"`
+			To   int    `json:"to"`
+		} `json:"inline"`
+	} `json:"comment"`
+	PullRequest BitbucketPullRequest `json:"pullrequest"`
+	Repository  BitbucketRepository  `json:"repository"`
+}
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FileStatus is how a pull/merge request changed a file
+type FileStatus string
+
+const (
+	FileAdded    FileStatus = "added"
+	FileModified FileStatus = "modified"
+	FileRemoved  FileStatus = "removed"
+	FileRenamed  FileStatus = "renamed"
+)
+
+// FileChange is the change of one file in a pull/merge request
+type FileChange struct {
+	Path string
+	// OldPath is the path before a rename, and the same as Path otherwise
+	OldPath   string
+	Status    FileStatus
+	Additions int
+	Deletions int
+	// Binary is set for binary files, which have no patch
+	Binary bool
+	// TooLarge is set when the VCS did not provide the patch because the
+	// diff is too large
+	TooLarge bool
+	// Language is the programming language of the file, guessed from its
+	// extension, or empty when unknown
+	Language string
+	// Patch is the unified diff of the file, starting at the first hunk
+	// header
+	Patch string
+	Hunks []Hunk
+}
+
+// Hunk is a hunk of a unified diff
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	// Section is the text after the range in the hunk header, usually the
+	// enclosing function
+	Section string
+	Lines   []DiffLine
+}
+
+// LineKind is the kind of a line in a hunk: the prefix it has in a unified
+// diff
+type LineKind byte
+
+const (
+	LineAdded   LineKind = '+'
+	LineRemoved LineKind = '-'
+	LineContext LineKind = ' '
+)
+
+// DiffLine is a line of a hunk
+type DiffLine struct {
+	Kind LineKind
+	Text string
+	// OldLine is the line number in the old file, 0 for added lines
+	OldLine int
+	// NewLine is the line number in the new file, 0 for removed lines
+	NewLine int
+}
+
+// Format renders the change as text for a prompt
+func (c FileChange) Format() string {
+	var b strings.Builder
+	b.WriteString("File: " + c.Path + "\n")
+
+	status := string(c.Status)
+	if c.Status == FileRenamed && c.OldPath != c.Path {
+		status += " from " + c.OldPath
+	}
+	if c.Language != "" {
+		status += ", " + c.Language
+	}
+	b.WriteString(fmt.Sprintf("Status: %s (+%d -%d)\n", status, c.Additions, c.Deletions))
+
+	b.WriteString("Patch:\n")
+	switch {
+	case c.Binary:
+		b.WriteString("(binary file)")
+	case c.TooLarge:
+		b.WriteString("(diff not available: too large)")
+	default:
+		b.WriteString(c.Patch)
+	}
+	return b.String()
+}
+
+// FormatChanges renders changes as text for a prompt
+func FormatChanges(changes []FileChange) string {
+	formatted := make([]string, 0, len(changes))
+	for _, change := range changes {
+		formatted = append(formatted, change.Format())
+	}
+	return strings.Join(formatted, "\n\n")
+}
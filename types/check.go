@@ -0,0 +1,22 @@
+package types
+
+// CheckConclusion is the outcome a check on the head commit reports
+type CheckConclusion string
+
+const (
+	CheckSuccess   CheckConclusion = "success"
+	CheckNeutral   CheckConclusion = "neutral"
+	CheckFailure   CheckConclusion = "failure"
+	CheckSkipped   CheckConclusion = "skipped"
+	CheckCancelled CheckConclusion = "cancelled"
+)
+
+// CheckResult is the result of a review reported as a check
+type CheckResult struct {
+	Conclusion CheckConclusion
+	// Title is a one-line description of the result
+	Title string
+	// Summary is the markdown text of the review
+	Summary  string
+	Findings []Finding
+}
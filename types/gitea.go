@@ -0,0 +1,56 @@
+package types
+
+// GiteaUser is the user block shared by Gitea event payloads
+type GiteaUser struct {
+	Login string `json:"login"`
+}
+
+// GiteaRepository is the repository block shared by Gitea event payloads
+type GiteaRepository struct {
+	FullName string `json:"full_name"`
+}
+
+// GiteaPullRequestEvent is sent when a pull request is opened, pushed to,
+// edited or labeled
+type GiteaPullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Number int    `json:"number"`
+		Title  string `json:"title"`
+		URL    string `json:"html_url"`
+		Draft  bool   `json:"draft"`
+		Labels []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+		User GiteaUser `json:"user"`
+		Head struct {
+			SHA string `json:"sha"`
+			Ref string `json:"ref"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository GiteaRepository `json:"repository"`
+	Sender     GiteaUser       `json:"sender"`
+}
+
+// GiteaIssueCommentEvent is sent when a comment on an issue or pull request
+// is created, edited or deleted
+type GiteaIssueCommentEvent struct {
+	Action string `json:"action"`
+	Issue  struct {
+		Number int    `json:"number"`
+		Title  string `json:"title"`
+		URL    string `json:"html_url"`
+	} `json:"issue"`
+	Comment struct {
+		ID   int64     `json:"id"`
+		Body string    `json:"body"`
+		User GiteaUser `json:"user"`
+	} `json:"comment"`
+	IsPull     bool            `json:"is_pull"`
+	Repository GiteaRepository `json:"repository"`
+	Sender     GiteaUser       `json:"sender"`
+}
@@ -0,0 +1,97 @@
+package types
+
+// GitHubRepository is the repository block shared by GitHub event payloads
+type GitHubRepository struct {
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+}
+
+// GitHubUser is the user block shared by GitHub event payloads
+type GitHubUser struct {
+	Login string `json:"login"`
+	Type  string `json:"type"`
+}
+
+// GitHubInstallation identifies the GitHub App installation a webhook was
+// delivered for. It is empty for repository webhooks.
+type GitHubInstallation struct {
+	ID int64 `json:"id"`
+}
+
+// GitHubPingEvent is sent when a webhook is created
+type GitHubPingEvent struct {
+	Zen    string `json:"zen"`
+	HookID int64  `json:"hook_id"`
+}
+
+// GitHubIssueCommentEvent is sent when a comment on an issue or pull request
+// is created, edited or deleted
+type GitHubIssueCommentEvent struct {
+	Action string `json:"action"`
+	Issue  struct {
+		Number      int    `json:"number"`
+		Title       string `json:"title"`
+		URL         string `json:"html_url"`
+		PullRequest *struct {
+			URL string `json:"url"`
+		} `json:"pull_request"`
+	} `json:"issue"`
+	Comment struct {
+		ID   int64      `json:"id"`
+		Body string     `json:"body"`
+		User GitHubUser `json:"user"`
+	} `json:"comment"`
+	Repository   GitHubRepository   `json:"repository"`
+	Sender       GitHubUser         `json:"sender"`
+	Installation GitHubInstallation `json:"installation"`
+}
+
+// IsPullRequest reports whether the comment was made on a pull request
+// rather than a plain issue
+func (e *GitHubIssueCommentEvent) IsPullRequest() bool {
+	return e.Issue.PullRequest != nil
+}
+
+// GitHubReviewCommentEvent is sent when a comment on a pull request diff is
+// created, edited or deleted
+type GitHubReviewCommentEvent struct {
+	Action  string `json:"action"`
+	Comment struct {
+		ID          int64      `json:"id"`
+		InReplyToID int64      `json:"in_reply_to_id"`
+		Body        string     `json:"body"`
+		Path        string     `json:"path"`
+		Line        int        `json:"line"`
+		DiffHunk    string     `json:"diff_hunk"`
+		CommitID    string     `json:"commit_id"`
+		User        GitHubUser `json:"user"`
+	} `json:"comment"`
+	PullRequest struct {
+		Number int    `json:"number"`
+		Title  string `json:"title"`
+		URL    string `json:"html_url"`
+	} `json:"pull_request"`
+	Repository   GitHubRepository   `json:"repository"`
+	Sender       GitHubUser         `json:"sender"`
+	Installation GitHubInstallation `json:"installation"`
+}
+
+// GitHubCheckSuiteEvent is sent when a check suite is requested, re-requested
+// or completed
+type GitHubCheckSuiteEvent struct {
+	Action     string `json:"action"`
+	CheckSuite struct {
+		ID           int64  `json:"id"`
+		HeadSHA      string `json:"head_sha"`
+		Status       string `json:"status"`
+		Conclusion   string `json:"conclusion"`
+		PullRequests []struct {
+			Number int `json:"number"`
+			Head   struct {
+				SHA string `json:"sha"`
+			} `json:"head"`
+		} `json:"pull_requests"`
+	} `json:"check_suite"`
+	Repository   GitHubRepository   `json:"repository"`
+	Installation GitHubInstallation `json:"installation"`
+}
@@ -0,0 +1,40 @@
+package types
+
+// GitLabProject is the project block shared by GitLab event payloads
+type GitLabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+// GitLabUser is the user block shared by GitLab event payloads
+type GitLabUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// GitLabNoteEvent is sent when a comment is posted on a merge request, issue,
+// commit or snippet
+type GitLabNoteEvent struct {
+	ObjectKind       string     `json:"object_kind"`
+	User             GitLabUser `json:"user"`
+	ObjectAttributes struct {
+		ID           int    `json:"id"`
+		Note         string `json:"note"`
+		NoteableType string `json:"noteable_type"`
+		DiscussionID string `json:"discussion_id"`
+		Type         string `json:"type"`
+	} `json:"object_attributes"`
+	MergeRequest struct {
+		IID        int    `json:"iid"`
+		Title      string `json:"title"`
+		URL        string `json:"url"`
+		LastCommit struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"merge_request"`
+	Project GitLabProject `json:"project"`
+}
+
+// IsMergeRequest reports whether the note was posted on a merge request
+func (e *GitLabNoteEvent) IsMergeRequest() bool {
+	return e.ObjectAttributes.NoteableType == "MergeRequest"
+}
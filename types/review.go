@@ -0,0 +1,131 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severities of findings, from most to least severe
+const (
+	SeverityCritical = "critical"
+	SeverityMajor    = "major"
+	SeverityMinor    = "minor"
+	SeverityNit      = "nit"
+)
+
+// CategorySecurity is the category of findings about security issues
+const CategorySecurity = "security"
+
+// Verdict is the outcome a review submits
+type Verdict string
+
+const (
+	// VerdictApprove approves the pull/merge request
+	VerdictApprove Verdict = "approve"
+	// VerdictComment comments without approving or blocking
+	VerdictComment Verdict = "comment"
+	// VerdictRequestChanges blocks the pull/merge request until it changes
+	VerdictRequestChanges Verdict = "request_changes"
+)
+
+// Finding is a review comment about a line, or a range of lines, of the
+// changes
+type Finding struct {
+	File string `json:"file"`
+	// Line is the line number in the new version of the file. For a range
+	// it is the last line.
+	Line int `json:"line"`
+	// StartLine is the first line of a range, 0 for a single line
+	StartLine int `json:"start_line,omitempty"`
+	// OldLine is the line number in the old version of the file when the
+	// line is unchanged context, and 0 when it was added. It is filled in
+	// when the finding is anchored to the diff.
+	OldLine int    `json:"old_line,omitempty"`
+	Body    string `json:"comment"`
+	// Severity is one of the Severity constants
+	Severity string `json:"severity,omitempty"`
+	// Category is the kind of issue, e.g. "security" or "performance"
+	Category string `json:"category,omitempty"`
+	// Suggestion is code that replaces the lines from StartLine, or Line,
+	// to Line
+	Suggestion string `json:"suggestion,omitempty"`
+	// Blocking is set by the review policy when the finding requests
+	// changes
+	Blocking bool `json:"blocking,omitempty"`
+}
+
+// FirstLine returns the first line the finding is about
+func (f Finding) FirstLine() int {
+	if f.StartLine > 0 && f.StartLine < f.Line {
+		return f.StartLine
+	}
+	return f.Line
+}
+
+// CommentBody renders the finding for an inline comment. A suggestion is
+// shown as a code block with the given info string, e.g. "suggestion" for a
+// GitHub suggested change; with an empty info string it is a plain block.
+func (f Finding) CommentBody(info string) string {
+	body := f.Body
+	if f.Severity != "" {
+		body = "**" + strings.ToUpper(f.Severity[:1]) + f.Severity[1:] + ":** " + body
+	}
+	if f.Suggestion == "" {
+		return body
+	}
+
+	code := strings.TrimSuffix(f.Suggestion, "\n")
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+
+	label := ""
+	if info == "" {
+		label = "**Suggested change:**\n"
+	}
+	return body + "\n\n" + label + fence + info + "\n" + code + "\n" + fence
+}
+
+// Markdown renders a finding for a review body, for findings that are not
+// posted as inline comments
+func (f Finding) Markdown() string {
+	if first := f.FirstLine(); first != f.Line {
+		return fmt.Sprintf("**`%s` lines %d-%d**: %s", f.File, first, f.Line, f.CommentBody(""))
+	}
+	return fmt.Sprintf("**`%s` line %d**: %s", f.File, f.Line, f.CommentBody(""))
+}
+
+// Review is a code review: an overall body plus findings about specific
+// lines, and the verdict it submits
+type Review struct {
+	Body     string
+	Findings []Finding
+	Verdict  Verdict
+	// Structured is set when the model answered in the structured format,
+	// so that its findings are complete
+	Structured bool
+	// ID identifies the review round, so that posting the same review again
+	// updates it rather than adding another round
+	ID string
+	// HeadSHA is the commit that was reviewed
+	HeadSHA string
+	// CheckID is the check reporting the review, or empty when no check is
+	// running
+	CheckID string
+}
+
+// FormatFindings renders findings as a markdown section appended to a review
+// body, or returns an empty string when there are none
+func FormatFindings(title string, findings []Finding) string {
+	if len(findings) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\n### " + title + "\n")
+	for _, finding := range findings {
+		b.WriteString("\n- " + finding.Markdown())
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package types
+
+// ThreadComment is a single comment in a review thread
+type ThreadComment struct {
+	Author string
+	Body   string
+	// Own is true for comments posted by the bot itself
+	Own bool
+}
+
+// ReviewThread is a discussion on a pull/merge request, oldest comment first
+type ReviewThread struct {
+	ID string
+	// File and DiffHunk locate the thread in the diff; both are empty for
+	// threads that are not attached to a line
+	File     string
+	DiffHunk string
+	Comments []ThreadComment
+}
+
+// StartedByBot reports whether the bot posted the first comment of the thread
+func (t *ReviewThread) StartedByBot() bool {
+	return len(t.Comments) > 0 && t.Comments[0].Own
+}
+
+// AwaitingReply reports whether the latest comment is from someone other than
+// the bot
+func (t *ReviewThread) AwaitingReply() bool {
+	return len(t.Comments) > 0 && !t.Comments[len(t.Comments)-1].Own
+}
@@ -7,10 +7,37 @@ type PRWebhook struct {
 		Title  string `json:"title"`
 		Body   string `json:"body"`
 		URL    string `json:"html_url"`
+		Draft  bool   `json:"draft"`
+		Labels []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			SHA string `json:"sha"`
+			Ref string `json:"ref"`
+		} `json:"base"`
+		Additions int `json:"additions"`
+		Deletions int `json:"deletions"`
 	} `json:"pull_request"`
+	// Label is the label added or removed by labeled and unlabeled events
+	Label *struct {
+		Name string `json:"name"`
+	} `json:"label"`
+	// Changes holds the previous values of fields changed by edited events
+	Changes struct {
+		Title *struct {
+			From string `json:"from"`
+		} `json:"title"`
+	} `json:"changes"`
 	Repository struct {
 		FullName string `json:"full_name"`
 	} `json:"repository"`
+	Installation GitHubInstallation `json:"installation"`
 }
 
 // GitLabWebhook represents a GitLab merge request webhook
@@ -21,13 +48,43 @@ type GitLabWebhook struct {
 		Username string `json:"username"`
 	} `json:"user"`
 	ObjectAttributes struct {
-		IID         int    `json:"iid"`
-		Title       string `json:"title"`
-		Description string `json:"description"`
-		URL         string `json:"url"`
-		Action      string `json:"action"`
+		IID            int    `json:"iid"`
+		Title          string `json:"title"`
+		Description    string `json:"description"`
+		URL            string `json:"url"`
+		Action         string `json:"action"`
+		Draft          bool   `json:"draft"`
+		WorkInProgress bool   `json:"work_in_progress"`
+		TargetBranch   string `json:"target_branch"`
+		// OldRev is the previous head SHA, set on update events that push new commits
+		OldRev     string `json:"oldrev"`
+		LastCommit struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
 	} `json:"object_attributes"`
 	Project struct {
 		PathWithNamespace string `json:"path_with_namespace"`
 	} `json:"project"`
-}
\ No newline at end of file
+	Labels []GitLabLabel `json:"labels"`
+	// Changes holds the previous and current values of fields changed by
+	// update events
+	Changes struct {
+		Labels *struct {
+			Previous []GitLabLabel `json:"previous"`
+			Current  []GitLabLabel `json:"current"`
+		} `json:"labels"`
+		Draft *struct {
+			Previous bool `json:"previous"`
+			Current  bool `json:"current"`
+		} `json:"draft"`
+		Title *struct {
+			Previous string `json:"previous"`
+			Current  string `json:"current"`
+		} `json:"title"`
+	} `json:"changes"`
+}
+
+// GitLabLabel is a label attached to a merge request
+type GitLabLabel struct {
+	Title string `json:"title"`
+}
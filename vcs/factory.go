@@ -2,8 +2,10 @@ package vcs
 
 import (
 	"fmt"
-	"os"
 
+	"pr-agent-reviewer/azuredevops"
+	"pr-agent-reviewer/bitbucket"
+	"pr-agent-reviewer/gitea"
 	"pr-agent-reviewer/github"
 	"pr-agent-reviewer/gitlab"
 )
@@ -16,21 +18,39 @@ const (
 	ProviderGitHub ProviderType = "github"
 	// ProviderGitLab represents the GitLab provider
 	ProviderGitLab ProviderType = "gitlab"
+	// ProviderBitbucket represents the Bitbucket Cloud provider
+	ProviderBitbucket ProviderType = "bitbucket"
+	// ProviderGitea represents the Gitea/Forgejo provider
+	ProviderGitea ProviderType = "gitea"
+	// ProviderAzureDevOps represents the Azure DevOps Repos provider
+	ProviderAzureDevOps ProviderType = "azuredevops"
 )
 
-// NewProvider creates a new VCS provider based on the configuration
-func NewProvider() (Provider, error) {
-	providerType := ProviderType(os.Getenv("VCS_PROVIDER"))
-	if providerType == "" {
-		providerType = ProviderGitHub // Default to GitHub
-	}
-
+// NewProvider creates a new VCS provider of the given type
+func NewProvider(providerType ProviderType) (Provider, error) {
 	switch providerType {
 	case ProviderGitHub:
-		return github.NewClient(), nil
+		if client := github.NewClient(); client != nil {
+			return client, nil
+		}
 	case ProviderGitLab:
-		return gitlab.NewClient(), nil
+		if client := gitlab.NewClient(); client != nil {
+			return client, nil
+		}
+	case ProviderBitbucket:
+		if client := bitbucket.NewClient(); client != nil {
+			return client, nil
+		}
+	case ProviderGitea:
+		if client := gitea.NewClient(); client != nil {
+			return client, nil
+		}
+	case ProviderAzureDevOps:
+		if client := azuredevops.NewClient(); client != nil {
+			return client, nil
+		}
 	default:
 		return nil, fmt.Errorf("unsupported VCS provider: %s", providerType)
 	}
-} 
\ No newline at end of file
+	return nil, fmt.Errorf("failed to initialize VCS provider: %s", providerType)
+}
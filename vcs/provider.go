@@ -1,10 +1,47 @@
 package vcs
 
+import (
+	"context"
+
+	"pr-agent-reviewer/types"
+)
+
 // Provider defines the interface for VCS providers
 type Provider interface {
 	// GetChanges gets the changes in a pull/merge request
-	GetChanges(repo string, prNumber int) ([]string, error)
+	GetChanges(ctx context.Context, repo string, prNumber int) ([]types.FileChange, error)
+
+	// GetChangesBetween gets the changes between two commits of a pull/merge request
+	GetChangesBetween(ctx context.Context, repo string, prNumber int, fromSHA, toSHA string) ([]types.FileChange, error)
+
+	// GetHeadSHA gets the SHA of the current head commit of a pull/merge request
+	GetHeadSHA(ctx context.Context, repo string, prNumber int) (string, error)
 	
-	// CreateReview creates a review on a pull/merge request
-	CreateReview(repo string, prNumber int, review string) error
-} 
\ No newline at end of file
+	// CreateReview creates a review on a pull/merge request and submits its
+	// verdict. The findings are anchored to lines of the diff and posted as
+	// inline comments; any the VCS rejects are appended to the review body
+	// instead.
+	CreateReview(ctx context.Context, repo string, prNumber int, review *types.Review) error
+
+	// PostComment posts a comment on a pull/merge request. When threadID is
+	// set the comment is posted as a reply in that thread.
+	PostComment(ctx context.Context, repo string, prNumber int, threadID, body string) error
+
+	// GetThread gets a review thread with all of its comments
+	GetThread(ctx context.Context, repo string, prNumber int, threadID string) (*types.ReviewThread, error)
+
+	// IsCollaborator reports whether a user may write to the repository
+	IsCollaborator(ctx context.Context, repo, username string) (bool, error)
+} 
+
+// CheckReporter is implemented by providers that can report a review as a
+// check on the head commit, so that branch protection can require it
+type CheckReporter interface {
+	// StartCheck marks the review of a pull/merge request as running and
+	// returns the ID of the check, or an empty ID when checks are disabled.
+	// The head of the pull/merge request is looked up when headSHA is empty.
+	StartCheck(ctx context.Context, repo string, prNumber int, headSHA string) (string, error)
+
+	// CompleteCheck reports the result of the review on a started check
+	CompleteCheck(ctx context.Context, repo string, prNumber int, checkID string, result *types.CheckResult) error
+}
@@ -0,0 +1,250 @@
+package vcs
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"pr-agent-reviewer/github"
+	"pr-agent-reviewer/gitlab"
+)
+
+// Host is a VCS instance the bot serves, with its own credentials and
+// webhook secret
+type Host struct {
+	// Name identifies the host in jobs and the review history. Hosts
+	// configured through the provider environment variables are named after
+	// their provider type.
+	Name string
+	Type ProviderType
+	// URL is the instance URL. It is empty for the public service.
+	URL           string
+	WebhookSecret string
+	Provider      Provider
+}
+
+// Hostname returns the host name that webhooks and URLs from the host carry
+func (h *Host) Hostname() string {
+	if h.URL != "" {
+		if u, err := url.Parse(h.URL); err == nil && u.Host != "" {
+			return strings.ToLower(u.Host)
+		}
+	}
+
+	switch h.Type {
+	case ProviderGitHub:
+		return "github.com"
+	case ProviderGitLab:
+		return "gitlab.com"
+	case ProviderBitbucket:
+		return "bitbucket.org"
+	case ProviderAzureDevOps:
+		return "dev.azure.com"
+	}
+	return ""
+}
+
+// Registry holds the VCS hosts the bot serves
+type Registry struct {
+	hosts []*Host
+}
+
+// NewRegistry creates every VCS host enabled by the configuration.
+//
+// The default hosts come from the provider environment variables:
+// VCS_PROVIDERS is a comma-separated list of providers to serve; when it is
+// unset the single VCS_PROVIDER is used, and when neither is set every
+// provider with credentials configured is enabled.
+//
+// VCS_HOSTS adds further GitHub Enterprise Server and GitLab instances, each
+// configured through VCS_HOST_<NAME>_* variables.
+func NewRegistry() (*Registry, error) {
+	var types []ProviderType
+	if list := os.Getenv("VCS_PROVIDERS"); list != "" {
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				types = append(types, ProviderType(name))
+			}
+		}
+	} else if single := os.Getenv("VCS_PROVIDER"); single != "" {
+		types = append(types, ProviderType(single))
+	} else {
+		if os.Getenv("GITHUB_TOKEN") != "" || os.Getenv("GITHUB_APP_ID") != "" {
+			types = append(types, ProviderGitHub)
+		}
+		if os.Getenv("GITLAB_TOKEN") != "" {
+			types = append(types, ProviderGitLab)
+		}
+		if os.Getenv("BITBUCKET_TOKEN") != "" || os.Getenv("BITBUCKET_APP_PASSWORD") != "" {
+			types = append(types, ProviderBitbucket)
+		}
+		if os.Getenv("GITEA_TOKEN") != "" {
+			types = append(types, ProviderGitea)
+		}
+		if os.Getenv("AZURE_DEVOPS_TOKEN") != "" {
+			types = append(types, ProviderAzureDevOps)
+		}
+	}
+
+	registry := &Registry{}
+	for _, providerType := range types {
+		provider, err := NewProvider(providerType)
+		if err != nil {
+			return nil, err
+		}
+		if err := registry.add(&Host{
+			Name:          string(providerType),
+			Type:          providerType,
+			URL:           defaultURL(providerType),
+			WebhookSecret: defaultWebhookSecret(providerType),
+			Provider:      provider,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range strings.Split(os.Getenv("VCS_HOSTS"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		host, err := newHost(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := registry.add(host); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(registry.hosts) == 0 {
+		return nil, fmt.Errorf("no VCS provider configured")
+	}
+	return registry, nil
+}
+
+// newHost creates a host listed in VCS_HOSTS from its VCS_HOST_<NAME>_*
+// variables
+func newHost(name string) (*Host, error) {
+	prefix := "VCS_HOST_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name)) + "_"
+	env := func(key string) string { return os.Getenv(prefix + key) }
+
+	host := &Host{
+		Name:          name,
+		Type:          ProviderType(env("TYPE")),
+		URL:           strings.TrimSuffix(env("URL"), "/"),
+		WebhookSecret: env("WEBHOOK_SECRET"),
+	}
+	if host.URL == "" {
+		return nil, fmt.Errorf("%sURL is not set for VCS host %s", prefix, name)
+	}
+
+	switch host.Type {
+	case ProviderGitHub:
+		if client := github.NewClientWithConfig(github.Config{
+			URL:               host.URL,
+			Token:             env("TOKEN"),
+			AppID:             env("APP_ID"),
+			AppPrivateKey:     env("APP_PRIVATE_KEY"),
+			AppPrivateKeyPath: env("APP_PRIVATE_KEY_PATH"),
+			CAFile:            env("CA_FILE"),
+			CheckRuns:         env("CHECK_RUNS"),
+		}); client != nil {
+			host.Provider = client
+		}
+	case ProviderGitLab:
+		if client := gitlab.NewClientWithConfig(gitlab.Config{
+			URL:          host.URL,
+			Token:        env("TOKEN"),
+			CAFile:       env("CA_FILE"),
+			CommitStatus: env("COMMIT_STATUS") != "false",
+		}); client != nil {
+			host.Provider = client
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %q for VCS host %s: must be github or gitlab", host.Type, name)
+	}
+
+	if host.Provider == nil {
+		return nil, fmt.Errorf("failed to initialize VCS host: %s", name)
+	}
+	return host, nil
+}
+
+func (r *Registry) add(host *Host) error {
+	if _, exists := r.Get(host.Name); exists {
+		return fmt.Errorf("duplicate VCS host: %s", host.Name)
+	}
+	r.hosts = append(r.hosts, host)
+	return nil
+}
+
+// Hosts returns every configured host
+func (r *Registry) Hosts() []*Host {
+	return r.hosts
+}
+
+// Get returns the host with the given name
+func (r *Registry) Get(name string) (*Host, bool) {
+	for _, host := range r.hosts {
+		if host.Name == name {
+			return host, true
+		}
+	}
+	return nil, false
+}
+
+// Resolve finds the host of the given type that a webhook came from, by the
+// host name its headers or payload carry. When only one host of the type is
+// configured it is used whatever the host name.
+func (r *Registry) Resolve(providerType ProviderType, hostname string) (*Host, bool) {
+	var candidates []*Host
+	for _, host := range r.hosts {
+		if host.Type != providerType {
+			continue
+		}
+		if hostname != "" && strings.EqualFold(host.Hostname(), hostname) {
+			return host, true
+		}
+		candidates = append(candidates, host)
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	return nil, false
+}
+
+// defaultURL returns the instance URL of a host configured through the
+// provider environment variables
+func defaultURL(providerType ProviderType) string {
+	switch providerType {
+	case ProviderGitHub:
+		return os.Getenv("GITHUB_URL")
+	case ProviderGitLab:
+		return os.Getenv("GITLAB_URL")
+	case ProviderGitea:
+		return os.Getenv("GITEA_URL")
+	case ProviderAzureDevOps:
+		return os.Getenv("AZURE_DEVOPS_ORG_URL")
+	}
+	return ""
+}
+
+// defaultWebhookSecret returns the webhook secret of a host configured
+// through the provider environment variables
+func defaultWebhookSecret(providerType ProviderType) string {
+	switch providerType {
+	case ProviderGitHub:
+		return os.Getenv("GITHUB_WEBHOOK_SECRET")
+	case ProviderGitLab:
+		return os.Getenv("GITLAB_WEBHOOK_TOKEN")
+	case ProviderBitbucket:
+		return os.Getenv("BITBUCKET_WEBHOOK_SECRET")
+	case ProviderGitea:
+		return os.Getenv("GITEA_WEBHOOK_SECRET")
+	case ProviderAzureDevOps:
+		return os.Getenv("AZURE_DEVOPS_WEBHOOK_SECRET")
+	}
+	return ""
+}
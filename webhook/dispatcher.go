@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"pr-agent-reviewer/logger"
+)
+
+// HandlerFunc handles the raw body of a webhook event
+type HandlerFunc func(w http.ResponseWriter, r *http.Request, body []byte)
+
+// Dispatcher routes webhook events to the handler registered for their type
+type Dispatcher struct {
+	name string
+
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+// NewDispatcher creates a new dispatcher. The name is used in log messages.
+func NewDispatcher(name string) *Dispatcher {
+	return &Dispatcher{
+		name:     name,
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+// Register sets the handler for an event type, replacing any previous one
+func (d *Dispatcher) Register(event string, handler HandlerFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[event] = handler
+}
+
+// Dispatch calls the handler registered for the event. Events without a
+// handler are acknowledged and ignored so that the sender does not retry them.
+func (d *Dispatcher) Dispatch(w http.ResponseWriter, r *http.Request, event string, body []byte) {
+	if event == "" {
+		logger.LogError("Missing "+d.name+" event type", nil)
+		http.Error(w, "Missing event type", http.StatusBadRequest)
+		return
+	}
+
+	d.mu.RLock()
+	handler, ok := d.handlers[event]
+	d.mu.RUnlock()
+
+	if !ok {
+		logger.LogInfo("Ignoring unhandled %s event: %s", d.name, event)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	handler(w, r, body)
+}
+
+// Typed adapts a handler of a decoded payload to a HandlerFunc. Payloads that
+// fail to decode are rejected with 400 Bad Request.
+func Typed[T any](handler func(w http.ResponseWriter, r *http.Request, payload *T)) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, body []byte) {
+		var payload T
+		if err := json.Unmarshal(body, &payload); err != nil {
+			logger.LogError("Failed to decode webhook payload", err)
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+		handler(w, r, &payload)
+	}
+}